@@ -0,0 +1,88 @@
+package main
+
+import (
+	"fmt"
+	"github.com/erik/gob/parse"
+	"os"
+)
+
+// `gob ast [--sexp | --json] [--func name] file...`: print the syntax
+// tree of each file, or of just the named function, to show how the
+// parser grouped things where String() would hide it.
+func dumpAST(files []string) {
+	if len(files) < 1 {
+		fmt.Println("Need to specify an input file")
+		return
+	}
+
+	for _, name := range files {
+		file, err := os.Open(name)
+		if err != nil {
+			fmt.Println(err)
+			os.Exit(1)
+		}
+
+		// A unit with syntax errors is still printed, with BadNodes
+		// covering what couldn't be parsed.
+		unit, err := parse.NewParser(name, file).Parse()
+		file.Close()
+
+		if err != nil {
+			fmt.Fprintln(os.Stderr, err)
+		}
+
+		defs := unit.Defs
+
+		if *funcName != "" {
+			defs = nil
+
+			for _, fn := range unit.Funcs {
+				if fn.Name == *funcName {
+					defs = append(defs, fn)
+				}
+			}
+
+			if len(defs) == 0 {
+				fmt.Fprintf(os.Stderr, "%s: no function named %s\n", name,
+					*funcName)
+				continue
+			}
+		}
+
+		if *jsonOut {
+			printASTJSON(unit, defs)
+			continue
+		}
+
+		if len(files) > 1 {
+			fmt.Printf("==== %s ====\n", name)
+		}
+
+		for _, def := range defs {
+			if *sexpOut {
+				err = parse.FprintSexp(os.Stdout, def)
+			} else {
+				err = parse.FprintTree(os.Stdout, def)
+			}
+
+			if err != nil {
+				fmt.Println(err)
+				os.Exit(1)
+			}
+		}
+	}
+}
+
+// The versioned encoding of parse.Marshal, one unit per line, holding
+// only the declarations asked for.
+func printASTJSON(unit parse.TranslationUnit, defs []parse.Node) {
+	unit.Defs = defs
+
+	data, err := parse.Marshal(unit)
+	if err != nil {
+		fmt.Println(err)
+		os.Exit(1)
+	}
+
+	fmt.Printf("%s\n", data)
+}
@@ -0,0 +1,389 @@
+// Package build constructs B programs in Go, for generating code from
+// tables: lookup vectors, state machines, message catalogs.
+//
+//	fn := build.Func("snide", "errno").Body(
+//		build.Extrn("mess"),
+//		build.Call(build.Ident("printf"), "error %d, %s\n",
+//			build.Ident("errno"),
+//			build.Index(build.Ident("mess"), build.Ident("errno"))))
+//
+//	unit, err := build.Unit("gen.b", fn,
+//		build.Vec("mess", "too bad", "tough luck"))
+//
+// Operands are parenthesized where their precedence needs it, so the
+// tree prints as it was built. Nodes carry no positions until Unit has
+// checked them.
+package build
+
+import (
+	"bytes"
+	"fmt"
+	"github.com/erik/gob/parse"
+	"github.com/erik/gob/printer"
+	"strings"
+)
+
+// Assemble definitions into a unit and check it's well formed: it has
+// to print as B which parses back to the same tree, and pass the same
+// semantic checks as a parsed file. The unit returned is the reparsed
+// one, so its positions, like those of any errors, refer to the source
+// printer.FprintUnit gives for it.
+func Unit(name string, defs ...parse.Node) (parse.TranslationUnit, error) {
+	unit := parse.TranslationUnit{File: name}
+
+	for _, def := range defs {
+		switch def.(type) {
+		case parse.FunctionNode:
+			unit.Funcs = append(unit.Funcs, def.(parse.FunctionNode))
+		case parse.ExternVarInitNode, parse.ExternVecInitNode:
+			if err := checkValues(name, def); err != nil {
+				return unit, err
+			}
+
+			unit.Vars = append(unit.Vars, def)
+		default:
+			return unit, fmt.Errorf("%s: `%v` is not a top level definition",
+				name, def)
+		}
+
+		unit.Defs = append(unit.Defs, def)
+	}
+
+	var src bytes.Buffer
+	if err := printer.FprintUnit(&src, unit, nil); err != nil {
+		return unit, err
+	}
+
+	parsed, err := parse.NewParser(name, &src).Parse()
+	if err != nil {
+		return unit, err
+	}
+
+	if len(parsed.Defs) != len(defs) {
+		return unit, fmt.Errorf("%s: definitions don't print back as "+
+			"themselves", name)
+	}
+
+	for i, def := range parsed.Defs {
+		if !parse.Equal(def, defs[i]) {
+			return unit, fmt.Errorf("%v: `%v` doesn't print back as "+
+				"itself", def.Pos(), defs[i])
+		}
+	}
+
+	return parsed, parsed.Verify()
+}
+
+// Initial values have to be constants or names. In particular, B has no
+// negative constants, so a negative number can't be one.
+func checkValues(name string, def parse.Node) error {
+	var values []parse.Node
+
+	switch d := def.(type) {
+	case parse.ExternVarInitNode:
+		values = d.Values
+	case parse.ExternVecInitNode:
+		values = d.Values
+	}
+
+	for _, val := range values {
+		switch v := val.(type) {
+		case parse.CharacterNode, parse.IdentNode, parse.IntegerNode,
+			parse.StringNode:
+			continue
+
+		case parse.UnaryNode:
+			if v.Oper == "-" {
+				return fmt.Errorf("%s: `%v` in `%v` is negative, and B "+
+					"has no negative initial values", name, val, def)
+			}
+		}
+
+		return fmt.Errorf("%s: `%v` in `%v` is not a constant or name",
+			name, val, def)
+	}
+
+	return nil
+}
+
+// Builds a function, given its body.
+type FuncBuilder struct {
+	name   string
+	params []string
+}
+
+// Start a function definition, finished with Body.
+func Func(name string, params ...string) FuncBuilder {
+	return FuncBuilder{name, params}
+}
+
+func (f FuncBuilder) Body(stmts ...parse.Node) parse.FunctionNode {
+	return parse.FunctionNode{Name: f.name, Params: f.params,
+		Body: Block(stmts...)}
+}
+
+// A scalar external, initialized with values as for Value. With no
+// values, it's zero. Negative numbers can't be initial values in B, so
+// Unit rejects them.
+func Var(name string, values ...interface{}) parse.ExternVarInitNode {
+	return parse.ExternVarInitNode{Name: name, Values: valueList(values)}
+}
+
+// A vector external, name[], holding just the values given.
+func Vec(name string, values ...interface{}) parse.ExternVecInitNode {
+	vec := parse.ExternVecInitNode{Name: name, InferSize: true,
+		Values: valueList(values)}
+
+	if len(values) > 0 {
+		vec.Size = len(values) - 1
+	}
+
+	return vec
+}
+
+// A vector external of size+1 words, as name[size], starting with the
+// values given.
+func VecSize(name string, size int,
+	values ...interface{}) parse.ExternVecInitNode {
+
+	return parse.ExternVecInitNode{Name: name, Size: size,
+		SizeExpr: Int(int64(size)), Values: valueList(values)}
+}
+
+// Convert a Go value to a constant: ints become numbers and strings B
+// strings. A Node is used as it is. Anything else is a programming
+// error, and panics.
+func Value(val interface{}) parse.Node {
+	switch v := val.(type) {
+	case parse.Node:
+		return v
+	case int:
+		return Int(int64(v))
+	case int64:
+		return Int(v)
+	case string:
+		return Str(v)
+	}
+
+	panic(fmt.Sprintf("build: can't make a B value from %T", val))
+}
+
+func valueList(values []interface{}) []parse.Node {
+	nodes := make([]parse.Node, len(values))
+
+	for i, val := range values {
+		nodes[i] = Value(val)
+	}
+
+	return nodes
+}
+
+// Statements
+
+// Expressions given as statements are made into them.
+func stmtList(stmts []parse.Node) []parse.Node {
+	nodes := make([]parse.Node, len(stmts))
+
+	for i, stmt := range stmts {
+		nodes[i] = Stmt(stmt)
+	}
+
+	return nodes
+}
+
+func Block(stmts ...parse.Node) parse.BlockNode {
+	return parse.BlockNode{Nodes: stmtList(stmts)}
+}
+
+// An expression statement. Anything already a statement is left alone.
+func Stmt(node parse.Node) parse.Node {
+	if parse.IsExpr(node) {
+		return parse.StatementNode{Expr: node}
+	}
+
+	return node
+}
+
+// auto name, ...;
+func Auto(names ...string) parse.VarDeclNode {
+	decl := parse.VarDeclNode{Vars: make([]parse.VarDecl, len(names))}
+
+	for i, name := range names {
+		decl.Vars[i] = parse.VarDecl{Name: name}
+	}
+
+	return decl
+}
+
+// auto name[size];
+func AutoVec(name string, size int) parse.VarDeclNode {
+	return parse.VarDeclNode{Vars: []parse.VarDecl{{Name: name,
+		VecDecl: true, Size: size, SizeExpr: Int(int64(size))}}}
+}
+
+func Extrn(names ...string) parse.ExternVarDeclNode {
+	return parse.ExternVarDeclNode{Names: names}
+}
+
+func If(cond parse.Node, body ...parse.Node) parse.IfNode {
+	return parse.IfNode{Cond: cond, Body: Block(body...)}
+}
+
+func IfElse(cond, body, elseBody parse.Node) parse.IfNode {
+	return parse.IfNode{Cond: cond, Body: Stmt(body), HasElse: true,
+		ElseBody: Stmt(elseBody)}
+}
+
+func While(cond parse.Node, body ...parse.Node) parse.WhileNode {
+	return parse.WhileNode{Cond: cond, Body: Block(body...)}
+}
+
+func Switch(cond parse.Node, body ...parse.Node) parse.SwitchNode {
+	return parse.SwitchNode{Cond: cond, Body: Block(body...)}
+}
+
+func Case(value int64) parse.CaseLabelNode {
+	return parse.CaseLabelNode{Cond: Int(value), Value: value}
+}
+
+func Default() parse.CaseLabelNode {
+	return parse.CaseLabelNode{Default: true}
+}
+
+func Label(name string) parse.LabelNode { return parse.LabelNode{Name: name} }
+
+func Goto(target parse.Node) parse.GotoNode {
+	return parse.GotoNode{Target: target}
+}
+
+func Break() parse.BreakNode { return parse.BreakNode{} }
+
+// return value; or with a nil value, just return;
+func Return(value parse.Node) parse.ReturnNode {
+	if value == nil {
+		return parse.ReturnNode{Node: parse.NullNode{}}
+	}
+
+	return parse.ReturnNode{Node: value}
+}
+
+// The empty statement
+func Null() parse.NullNode { return parse.NullNode{} }
+
+// Expressions
+
+func Ident(name string) parse.IdentNode { return parse.IdentNode{Value: name} }
+
+// A decimal number. B has no negative literals, so a negative one is
+// negated.
+func Int(val int64) parse.Node {
+	if val < 0 {
+		return parse.UnaryNode{Oper: "-",
+			Node: parse.IntegerNode{Value: -val, Radix: 10}}
+	}
+
+	return parse.IntegerNode{Value: val, Radix: 10}
+}
+
+func Octal(val int64) parse.IntegerNode {
+	return parse.IntegerNode{Value: val, Radix: 8}
+}
+
+// A character constant holding chars, escaped as needed.
+func Char(chars string) parse.CharacterNode {
+	val, _ := parse.DefaultTarget.PackChars([]byte(chars))
+
+	// Too many characters to pack are caught by Unit
+	return parse.CharacterNode{Text: escape(chars, '\''), Value: val}
+}
+
+// A string holding str, escaped as needed.
+func Str(str string) parse.StringNode {
+	return parse.StringNode{Value: escape(str, '"')}
+}
+
+var escapes = map[byte]string{
+	0: "*0", 4: "*e", '\t': "*t", '\n': "*n", '*': "**",
+}
+
+// Escape the characters in str which can't be written as they are
+// between quote characters.
+func escape(str string, quote byte) string {
+	var buf strings.Builder
+
+	for i := 0; i < len(str); i++ {
+		if esc, ok := escapes[str[i]]; ok {
+			buf.WriteString(esc)
+		} else if str[i] == quote {
+			buf.WriteString("*" + string(quote))
+		} else {
+			buf.WriteByte(str[i])
+		}
+	}
+
+	return buf.String()
+}
+
+// Parenthesize node if it binds less tightly than prec.
+func operand(node parse.Node, prec int) parse.Node {
+	if parse.Precedence(node) < prec {
+		return Paren(node)
+	}
+
+	return node
+}
+
+func Paren(node parse.Node) parse.ParenNode {
+	return parse.ParenNode{Node: node}
+}
+
+// left op right, where op is any binary operator, assignments included.
+func Binary(left parse.Node, op string, right parse.Node) parse.BinaryNode {
+	prec, _ := parse.OperatorPrecedence(op)
+
+	// Assignments group right to left, everything else left to right
+	if parse.IsAssignOp(op) {
+		left, right = operand(left, prec+1), operand(right, prec)
+	} else {
+		left, right = operand(left, prec), operand(right, prec+1)
+	}
+
+	return parse.BinaryNode{Left: left, Oper: op, Right: right}
+}
+
+func Assign(left, right parse.Node) parse.BinaryNode {
+	return Binary(left, "=", right)
+}
+
+// cond ? a : b
+func Ternary(cond, a, b parse.Node) parse.TernaryNode {
+	prec, _ := parse.OperatorPrecedence("?")
+
+	return parse.TernaryNode{Cond: operand(cond, prec+1), TrueBody: a,
+		FalseBody: operand(b, prec)}
+}
+
+// A prefix operator: one of * & - ! ~ ++ --
+func Unary(op string, node parse.Node) parse.UnaryNode {
+	return parse.UnaryNode{Oper: op,
+		Node: operand(node, parse.UnaryPrecedence)}
+}
+
+// A postfix ++ or --
+func Postfix(op string, node parse.Node) parse.UnaryNode {
+	return parse.UnaryNode{Oper: op,
+		Node: operand(node, parse.PrimaryPrecedence), Postfix: true}
+}
+
+// Call a function, with arguments as for Value.
+func Call(fn parse.Node, args ...interface{}) parse.FunctionCallNode {
+	return parse.FunctionCallNode{
+		Callable: operand(fn, parse.PrimaryPrecedence),
+		Args:     valueList(args)}
+}
+
+// array[index]
+func Index(array, index parse.Node) parse.ArrayAccessNode {
+	return parse.ArrayAccessNode{
+		Array: operand(array, parse.PrimaryPrecedence), Index: index}
+}
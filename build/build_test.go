@@ -0,0 +1,179 @@
+package build
+
+import (
+	"github.com/erik/gob/emit"
+	"github.com/erik/gob/parse"
+	"github.com/erik/gob/printer"
+	"strings"
+	"testing"
+)
+
+// examples/snide.b, built by hand
+func TestUnitSnide(t *testing.T) {
+	snide := Func("snide", "errno").Body(
+		Extrn("wr.unit", "mess"),
+		Auto("u"),
+		Assign(Ident("u"), Ident("wr.unit")),
+		Assign(Ident("wr.unit"), Int(1)),
+		Call(Ident("printf"), "error number %d, %s\n", Ident("errno"),
+			Index(Ident("mess"), Ident("errno"))),
+		Assign(Ident("wr.unit"), Ident("u")))
+
+	mess := VecSize("mess", 5, "too bad", "tough luck", "sorry, Charlie",
+		"that's the breaks", "what a shame", "some days you can't win")
+
+	unit, err := Unit("snide.b", snide, mess, Var("wr.unit"))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	var src strings.Builder
+	if err := printer.FprintUnit(&src, unit, nil); err != nil {
+		t.Fatal(err)
+	}
+
+	expected := `snide(errno) {
+	extrn wr.unit, mess;
+	auto u;
+	u = wr.unit;
+	wr.unit = 1;
+	printf("error number %d, %s*n", errno, mess[errno]);
+	wr.unit = u;
+}
+
+mess[5] "too bad", "tough luck", "sorry, Charlie", "that's the breaks", ` +
+		`"what a shame", "some days you can't win";
+wr.unit;
+`
+
+	if src.String() != expected {
+		t.Errorf("Expected:\n%s\ngot:\n%s", expected, src.String())
+	}
+
+	// Positions are those of the printed source
+	body := unit.Funcs[0].Body.(parse.BlockNode)
+	if pos := body.Nodes[2].Pos(); pos.Line != 4 {
+		t.Errorf("Expected the assignment on line 4, got %v", pos)
+	}
+
+	var c strings.Builder
+	var emitter emit.CEmitter
+	if err := emitter.Emit(&c, unit); err != nil {
+		t.Fatal(err)
+	}
+
+	if !strings.Contains(c.String(), "mess[errno]") {
+		t.Errorf("Unexpected C output:\n%s", c.String())
+	}
+}
+
+// A state machine, with operands needing parens
+func TestUnitStateMachine(t *testing.T) {
+	step := Func("step", "state", "c").Body(
+		Switch(Ident("state"),
+			Case(0),
+			If(Binary(Ident("c"), "==", Char("a")), Return(Int(1))),
+			Break(),
+			Case(-1),
+			Return(Ternary(Assign(Ident("c"), Int(0)), Ident("c"),
+				Assign(Ident("state"), Int(2)))),
+			Default(),
+			Goto(Ident("out"))),
+		Label("out"),
+		Return(Binary(Binary(Ident("state"), "+", Int(1)), "*",
+			Unary("-", Binary(Ident("c"), "-", Int(1))))))
+
+	unit, err := Unit("step.b", step)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	var src strings.Builder
+	if err := printer.Fprint(&src, unit.Funcs[0]); err != nil {
+		t.Fatal(err)
+	}
+
+	expected := `step(state, c) {
+	switch (state) {
+	case 0:
+		if (c == 'a') {
+			return 1;
+		}
+		break;
+	case -1:
+		return (c = 0) ? c : (state = 2);
+	default:
+		goto out;
+	}
+out:
+	return (state + 1) * -(c - 1);
+}`
+
+	if src.String() != expected {
+		t.Errorf("Expected:\n%s\ngot:\n%s", expected, src.String())
+	}
+}
+
+func TestUnitErrors(t *testing.T) {
+	var tests = []struct {
+		defs []parse.Node
+		err  string
+	}{
+		// Not an identifier
+		{[]parse.Node{Var("1x")}, "Parse error"},
+		{[]parse.Node{Func("f").Body(Return(Ident("if")))}, "Parse error"},
+		// Not B
+		{[]parse.Node{Func("f").Body(Unary("+", Ident("x")))},
+			"invalid unary op"},
+		{[]parse.Node{Func("f").Body(parse.BadNode{})}, "can't print"},
+		{[]parse.Node{Auto("x")}, "not a top level definition"},
+		// Semantic errors
+		{[]parse.Node{Func("f").Body(Goto(Ident("nowhere")))},
+			"unresolved goto"},
+		{[]parse.Node{Var("x"), Var("x")}, "Duplicate variable name"},
+		// Initial values
+		{[]parse.Node{Vec("tab", -1, 2)}, "B has no negative initial values"},
+		{[]parse.Node{Var("x", Binary(Int(1), "+", Int(1)))},
+			"not a constant or name"},
+		// Printed differently from how it was built
+		{[]parse.Node{Func("f").Body(Stmt(parse.BinaryNode{
+			Left: Binary(Ident("a"), "+", Ident("b")), Oper: "*",
+			Right: Ident("c")}))}, "doesn't print back"},
+	}
+
+	for _, test := range tests {
+		if _, err := Unit("x.b", test.defs...); err == nil {
+			t.Errorf("%v: expected an error", test.defs)
+		} else if !strings.Contains(err.Error(), test.err) {
+			t.Errorf("%v: expected %q, got %v", test.defs, test.err, err)
+		}
+	}
+}
+
+func TestValues(t *testing.T) {
+	var tests = []struct {
+		node     parse.Node
+		expected string
+	}{
+		{Str("a*b\n\t\"q\""), `"a**b*n*t*"q*""`},
+		{Char("'\n"), `'*'*n'`},
+		{Int(-5), "-5"},
+		{Octal(8), "010"},
+		{Vec("v"), "v [];"},
+		{Vec("v", 1, "s", Ident("v")), `v [] 1, "s", v;`},
+	}
+
+	for _, test := range tests {
+		if str := test.node.String(); str != test.expected {
+			t.Errorf("Expected %s, got %s", test.expected, str)
+		}
+	}
+
+	defer func() {
+		if recover() == nil {
+			t.Errorf("Expected a panic for a float value")
+		}
+	}()
+
+	Value(1.5)
+}
@@ -0,0 +1,354 @@
+// Package diff compares two versions of a B program by their syntax
+// trees, so that layout and comments make no difference.
+package diff
+
+import (
+	"bytes"
+	"fmt"
+	"github.com/erik/gob/parse"
+	"github.com/erik/gob/printer"
+	"io"
+	"reflect"
+	"strings"
+)
+
+type Kind int
+
+const (
+	Added Kind = iota
+	Removed
+	Changed
+)
+
+func (k Kind) String() string {
+	switch k {
+	case Added:
+		return "added"
+	case Removed:
+		return "removed"
+	case Changed:
+		return "changed"
+	}
+
+	return "unknown"
+}
+
+// A definition or statement which differs. Old is nil for an addition,
+// New for a removal.
+type Change struct {
+	Kind     Kind
+	Name     string // of a definition, empty for statements
+	Old, New parse.Node
+	Stmts    []Change // the statements which differ in a changed function
+}
+
+// The definitions which differ between two units, matched up by name.
+// Those in a come first, in order, followed by any only found in b.
+// Moving a definition around is not a change.
+func Units(a, b parse.TranslationUnit) []Change {
+	var changes []Change
+
+	oldKeys, oldDefs := definitions(a)
+	newKeys, newDefs := definitions(b)
+
+	for _, key := range oldKeys {
+		old, new := oldDefs[key], newDefs[key]
+
+		switch {
+		case new == nil:
+			changes = append(changes, Change{Kind: Removed,
+				Name: defName(old), Old: old})
+
+		case !parse.Equal(old, new):
+			change := Change{Kind: Changed, Name: defName(old), Old: old,
+				New: new}
+
+			oldFn, ok1 := old.(parse.FunctionNode)
+			newFn, ok2 := new.(parse.FunctionNode)
+
+			if ok1 && ok2 {
+				change.Stmts = Stmts(body(oldFn.Body), body(newFn.Body))
+			}
+
+			changes = append(changes, change)
+		}
+	}
+
+	for _, key := range newKeys {
+		if oldDefs[key] == nil {
+			changes = append(changes, Change{Kind: Added,
+				Name: defName(newDefs[key]), New: newDefs[key]})
+		}
+	}
+
+	return changes
+}
+
+// The definitions of a unit by name, and the names in order. A name
+// defined more than once is told apart by how many times it was seen
+// before.
+func definitions(unit parse.TranslationUnit) ([]string,
+	map[string]parse.Node) {
+
+	var keys []string
+	defs := map[string]parse.Node{}
+	count := map[string]int{}
+
+	for _, def := range unit.Definitions() {
+		name := defName(def)
+		key := fmt.Sprintf("%s#%d", name, count[name])
+		count[name]++
+
+		keys = append(keys, key)
+		defs[key] = def
+	}
+
+	return keys, defs
+}
+
+func defName(def parse.Node) string {
+	switch d := def.(type) {
+	case parse.FunctionNode:
+		return d.Name
+	case parse.ExternVarInitNode:
+		return d.Name
+	case parse.ExternVecInitNode:
+		return d.Name
+	}
+
+	return ""
+}
+
+// The statements of a block, or the single statement standing in for
+// one.
+func body(node parse.Node) []parse.Node {
+	if block, ok := node.(parse.BlockNode); ok {
+		return block.Nodes
+	}
+
+	return []parse.Node{node}
+}
+
+// The statements which differ between two lists, found from their
+// longest common subsequence. A removed statement followed by an added
+// one of the same kind is reported as a change; where both are loops,
+// switches, blocks or ifs which are the same apart from their bodies,
+// the changes inside the bodies are reported instead.
+func Stmts(a, b []parse.Node) []Change {
+	// lcs[i][j] is the length of the longest common subsequence of
+	// a[i:] and b[j:]
+	lcs := make([][]int, len(a)+1)
+	for i := range lcs {
+		lcs[i] = make([]int, len(b)+1)
+	}
+
+	for i := len(a) - 1; i >= 0; i-- {
+		for j := len(b) - 1; j >= 0; j-- {
+			if parse.Equal(a[i], b[j]) {
+				lcs[i][j] = lcs[i+1][j+1] + 1
+			} else if lcs[i+1][j] >= lcs[i][j+1] {
+				lcs[i][j] = lcs[i+1][j]
+			} else {
+				lcs[i][j] = lcs[i][j+1]
+			}
+		}
+	}
+
+	var removed, added []parse.Node
+	var out []Change
+
+	i, j := 0, 0
+	for i < len(a) || j < len(b) {
+		switch {
+		case i < len(a) && j < len(b) && parse.Equal(a[i], b[j]):
+			out = append(out, pair(removed, added)...)
+			removed, added = nil, nil
+			i, j = i+1, j+1
+
+		case j == len(b) || (i < len(a) && lcs[i+1][j] >= lcs[i][j+1]):
+			removed = append(removed, a[i])
+			i++
+
+		default:
+			added = append(added, b[j])
+			j++
+		}
+	}
+
+	return append(out, pair(removed, added)...)
+}
+
+// Match up a run of removed statements with the added ones replacing
+// them.
+func pair(removed, added []parse.Node) []Change {
+	var changes []Change
+
+	for len(removed) > 0 && len(added) > 0 {
+		old, new := removed[0], added[0]
+
+		if reflect.TypeOf(old) != reflect.TypeOf(new) {
+			break
+		}
+
+		if oldBody, newBody, ok := bodies(old, new); ok {
+			changes = append(changes, Stmts(oldBody, newBody)...)
+		} else {
+			changes = append(changes, Change{Kind: Changed, Old: old,
+				New: new})
+		}
+
+		removed, added = removed[1:], added[1:]
+	}
+
+	for _, old := range removed {
+		changes = append(changes, Change{Kind: Removed, Old: old})
+	}
+
+	for _, new := range added {
+		changes = append(changes, Change{Kind: Added, New: new})
+	}
+
+	return changes
+}
+
+// The statements inside two statements of the same kind which differ
+// only in them.
+func bodies(a, b parse.Node) ([]parse.Node, []parse.Node, bool) {
+	switch a := a.(type) {
+	case parse.BlockNode:
+		return a.Nodes, b.(parse.BlockNode).Nodes, true
+
+	case parse.IfNode:
+		b := b.(parse.IfNode)
+
+		if !parse.Equal(a.Cond, b.Cond) || a.HasElse != b.HasElse {
+			return nil, nil, false
+		}
+
+		if parse.Equal(a.ElseBody, b.ElseBody) {
+			return body(a.Body), body(b.Body), true
+		}
+		if parse.Equal(a.Body, b.Body) {
+			return body(a.ElseBody), body(b.ElseBody), true
+		}
+
+	case parse.SwitchNode:
+		b := b.(parse.SwitchNode)
+
+		if parse.Equal(a.Cond, b.Cond) {
+			return body(a.Body), body(b.Body), true
+		}
+
+	case parse.WhileNode:
+		b := b.(parse.WhileNode)
+
+		if parse.Equal(a.Cond, b.Cond) {
+			return body(a.Body), body(b.Body), true
+		}
+	}
+
+	return nil, nil, false
+}
+
+// Print changes for people to read:
+//
+//	function convert changed:
+//		a.b:12:3, b.b:12:3: changed
+//			- i = 0;
+//			+ i = 1;
+//	function lower removed
+//	extern v changed:
+//		- v[] 1, 2;
+//		+ v[] 1, 3;
+func Fprint(w io.Writer, changes []Change) error {
+	var buf bytes.Buffer
+
+	for _, change := range changes {
+		def := change.New
+		if def == nil {
+			def = change.Old
+		}
+
+		kind := "extern"
+		if _, ok := def.(parse.FunctionNode); ok {
+			kind = "function"
+		}
+
+		if change.Kind != Changed {
+			fmt.Fprintf(&buf, "%s %s %v\n", kind, change.Name, change.Kind)
+			continue
+		}
+
+		fmt.Fprintf(&buf, "%s %s changed:\n", kind, change.Name)
+
+		oldFn, ok1 := change.Old.(parse.FunctionNode)
+		newFn, ok2 := change.New.(parse.FunctionNode)
+
+		if !ok1 || !ok2 {
+			// Externals are short, show them whole
+			if err := printLines(&buf, "\t- ", change.Old); err != nil {
+				return err
+			}
+			if err := printLines(&buf, "\t+ ", change.New); err != nil {
+				return err
+			}
+			continue
+		}
+
+		oldParams := strings.Join(oldFn.Params, ", ")
+		newParams := strings.Join(newFn.Params, ", ")
+
+		if oldParams != newParams {
+			fmt.Fprintf(&buf, "\t- %s(%s)\n\t+ %s(%s)\n", oldFn.Name,
+				oldParams, newFn.Name, newParams)
+		}
+
+		for _, stmt := range change.Stmts {
+			if err := printStmt(&buf, stmt); err != nil {
+				return err
+			}
+		}
+	}
+
+	_, err := w.Write(buf.Bytes())
+	return err
+}
+
+func printStmt(buf *bytes.Buffer, change Change) error {
+	var where []string
+
+	for _, node := range []parse.Node{change.Old, change.New} {
+		if node != nil {
+			where = append(where, node.Pos().String())
+		}
+	}
+
+	fmt.Fprintf(buf, "\t%s: %v\n", strings.Join(where, ", "), change.Kind)
+
+	if change.Old != nil {
+		if err := printLines(buf, "\t\t- ", change.Old); err != nil {
+			return err
+		}
+	}
+
+	if change.New != nil {
+		return printLines(buf, "\t\t+ ", change.New)
+	}
+
+	return nil
+}
+
+// Print node in canonical form, with each line prefixed.
+func printLines(buf *bytes.Buffer, prefix string, node parse.Node) error {
+	var src bytes.Buffer
+
+	if err := printer.Fprint(&src, node); err != nil {
+		return err
+	}
+
+	for _, line := range strings.Split(src.String(), "\n") {
+		buf.WriteString(prefix + line + "\n")
+	}
+
+	return nil
+}
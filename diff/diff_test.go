@@ -0,0 +1,152 @@
+package diff
+
+import (
+	"github.com/erik/gob/parse"
+	"strings"
+	"testing"
+)
+
+func parseUnit(t *testing.T, name, src string) parse.TranslationUnit {
+	unit, err := parse.NewParser(name, strings.NewReader(src)).Parse()
+	if err != nil {
+		t.Fatalf("%s: %v", name, err)
+	}
+
+	return unit
+}
+
+func TestUnitsIgnoresLayout(t *testing.T) {
+	a := parseUnit(t, "a.b", `v 1; f(x) { /* hi */ return (x+1); } g() ;`)
+	b := parseUnit(t, "b.b", `g()
+	;
+
+f(x) {
+	return (x + 1);
+}
+
+v 1;`)
+
+	if changes := Units(a, b); len(changes) != 0 {
+		t.Errorf("Expected no changes, got %v", changes)
+	}
+}
+
+func TestUnits(t *testing.T) {
+	a := parseUnit(t, "a.b", `v[] 1, 2;
+old() ;
+f(x) {
+	auto i;
+	i = 0;
+	while (i < x) {
+		print(i);
+		i++;
+	}
+	return (i);
+}`)
+	b := parseUnit(t, "b.b", `v[] 1, 3;
+f(x) {
+	auto i;
+	i = 1;
+	while (i < x) {
+		print(i);
+		check(i);
+		i++;
+	}
+}
+new() ;`)
+
+	expected := `extern v changed:
+	- v[] 1, 2;
+	+ v[] 1, 3;
+function old removed
+function f changed:
+	a.b:5:2, b.b:4:2: changed
+		- i = 0;
+		+ i = 1;
+	b.b:7:3: added
+		+ check(i);
+	a.b:10:2: removed
+		- return (i);
+function new added
+`
+
+	var buf strings.Builder
+	if err := Fprint(&buf, Units(a, b)); err != nil {
+		t.Fatal(err)
+	}
+
+	if buf.String() != expected {
+		t.Errorf("Expected:\n%s\ngot:\n%s", expected, buf.String())
+	}
+}
+
+func TestStmts(t *testing.T) {
+	stmts := func(src string) []parse.Node {
+		node, err := parse.ParseStmt("x.b", src)
+		if err != nil {
+			t.Fatalf("%s: %v", src, err)
+		}
+		return node.(parse.BlockNode).Nodes
+	}
+
+	var tests = []struct {
+		a, b  string
+		kinds []Kind
+	}{
+		{"{ a; b; c; }", "{ a; b; c; }", nil},
+		{"{ a; b; c; }", "{ a; c; }", []Kind{Removed}},
+		{"{ a; c; }", "{ a; b; c; }", []Kind{Added}},
+		{"{ a = 1; }", "{ a = 2; }", []Kind{Changed}},
+		// Different kinds of statement don't pair up
+		{"{ a; }", "{ return; }", []Kind{Removed, Added}},
+		// Only the changed branch of an if is looked into
+		{"{ if (x) { a; b; } else c; }", "{ if (x) { a; d; } else c; }",
+			[]Kind{Changed}},
+		// but a changed condition changes the whole statement
+		{"{ if (x) a; }", "{ if (y) a; }", []Kind{Changed}},
+		{"{ switch (x) { case 1: a; } }", "{ switch (x) { case 2: a; } }",
+			[]Kind{Changed}},
+	}
+
+	for _, test := range tests {
+		changes := Stmts(stmts(test.a), stmts(test.b))
+
+		if len(changes) != len(test.kinds) {
+			t.Errorf("%s -> %s: expected %v, got %v", test.a, test.b,
+				test.kinds, changes)
+			continue
+		}
+
+		for i, change := range changes {
+			if change.Kind != test.kinds[i] {
+				t.Errorf("%s -> %s: expected %v, got %v", test.a, test.b,
+					test.kinds, changes)
+				break
+			}
+		}
+	}
+
+	// The statement changed inside the if is reported, not the if
+	changes := Stmts(stmts("{ if (x) { a; b; } }"),
+		stmts("{ if (x) { a; d; } }"))
+
+	if len(changes) != 1 || changes[0].Old.String() != "b;" ||
+		changes[0].New.String() != "d;" {
+		t.Errorf("Expected b; changed to d;, got %v", changes)
+	}
+}
+
+// Units put together without Defs are compared by their functions and
+// externals.
+func TestUnitsWithoutDefs(t *testing.T) {
+	a := parseUnit(t, "a.b", `v 1; f() { return (1); }`)
+	b := parseUnit(t, "b.b", `v 1; f() { return (2); }`)
+
+	b = parse.TranslationUnit{File: b.File, Funcs: b.Funcs, Vars: b.Vars}
+
+	changes := Units(a, b)
+	if len(changes) != 1 || changes[0].Kind != Changed ||
+		changes[0].Name != "f" {
+		t.Errorf("Expected f changed, got %v", changes)
+	}
+}
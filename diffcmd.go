@@ -0,0 +1,49 @@
+package main
+
+import (
+	"fmt"
+	"github.com/erik/gob/diff"
+	"github.com/erik/gob/parse"
+	"os"
+)
+
+// `gob diff old.b new.b`: report the functions and externals which
+// differ between two files, and the statements which differ in each
+// function, ignoring layout and comments. As with diff(1), the exit
+// status is 1 if there were differences and 2 if there was trouble.
+func diffFiles(files []string) {
+	if len(files) != 2 {
+		fmt.Println("Need to specify two files to compare")
+		os.Exit(2)
+	}
+
+	var units [2]parse.TranslationUnit
+
+	for i, name := range files {
+		file, err := os.Open(name)
+		if err != nil {
+			fmt.Println(err)
+			os.Exit(2)
+		}
+
+		units[i], err = parse.NewParser(name, file).Parse()
+		file.Close()
+
+		// Comparing around syntax errors would only mislead
+		if err != nil {
+			fmt.Fprintln(os.Stderr, err)
+			os.Exit(2)
+		}
+	}
+
+	changes := diff.Units(units[0], units[1])
+
+	if err := diff.Fprint(os.Stdout, changes); err != nil {
+		fmt.Println(err)
+		os.Exit(2)
+	}
+
+	if len(changes) > 0 {
+		os.Exit(1)
+	}
+}
@@ -11,6 +11,11 @@ import (
 type CEmitter struct {
 	writer *bufio.Writer
 	indent int
+	labels map[string]parse.LabelNode         // in the current function
+	extrns map[string]parse.ExternVarDeclNode // in the current function
+
+	// Scalars with several values, which are stored as arrays
+	arrays map[string]bool
 }
 
 func (c CEmitter) Emit(writer io.Writer, unit parse.TranslationUnit) error {
@@ -19,16 +24,26 @@ func (c CEmitter) Emit(writer io.Writer, unit parse.TranslationUnit) error {
 
 	c.EmitHeaders(unit)
 
+	// Prototypes come first, as globals may be initialized with the
+	// address of a function.
+	c.EmitLine("\n/* Function prototypes */")
+
+	for _, f := range unit.Funcs {
+		c.EmitFunctionProto(f)
+	}
+
 	c.EmitLine("\n/* Global variables */")
 
+	c.arrays = map[string]bool{}
+
 	for _, v := range unit.Vars {
-		c.EmitGlobal(v)
+		c.EmitGlobalDecl(v)
 	}
 
-	c.EmitLine("\n/* Function prototypes */")
+	c.EmitLine("")
 
-	for _, f := range unit.Funcs {
-		c.EmitFunctionProto(f)
+	for _, v := range unit.Vars {
+		c.EmitGlobal(v)
 	}
 
 	c.EmitLine("\n/* Function definitions */")
@@ -56,30 +71,91 @@ func (c *CEmitter) EmitHeaders(unit parse.TranslationUnit) {
 	c.EmitLine("")
 }
 
+// Globals can refer to each other in any order, so they're all declared
+// (as C tentative definitions) before any is initialized.
+func (c *CEmitter) EmitGlobalDecl(v parse.Node) {
+	switch v.(type) {
+	case parse.ExternVarInitNode:
+		var_ := v.(parse.ExternVarInitNode)
+		name := sanitizeIdentifier(var_.Name)
+
+		if len(var_.Values) > 1 {
+			c.EmitLine(fmt.Sprintf("static B_AUTO %s[%d];", name,
+				len(var_.Values)))
+			c.arrays[var_.Name] = true
+		} else {
+			c.EmitLine(fmt.Sprintf("static B_AUTO %s;", name))
+		}
+
+	case parse.ExternVecInitNode:
+		vec := v.(parse.ExternVecInitNode)
+		c.EmitLine(fmt.Sprintf("static B_AUTO %s[%d];",
+			sanitizeIdentifier(vec.Name), vec.Size+1))
+	}
+}
+
 func (c *CEmitter) EmitGlobal(v parse.Node) {
 	switch v.(type) {
 	case parse.ExternVarInitNode:
 		var_ := v.(parse.ExternVarInitNode)
-		c.EmitLine(fmt.Sprintf("static B_AUTO %v = %v", var_.Name,
-			var_.Value))
+		name := sanitizeIdentifier(var_.Name)
+
+		switch len(var_.Values) {
+		case 0:
+			// Zero filled by the declaration
+		case 1:
+			c.EmitPartial(fmt.Sprintf("static B_AUTO %s = ", name))
+			c.EmitInitializer(var_.Values[0])
+			c.EmitRaw(";\n")
+		default:
+			// A scalar with several values spills into the following
+			// words, so it's stored as an array. Its address is
+			// still that of the first word.
+			c.EmitPartial(fmt.Sprintf("static B_AUTO %s[%d] = ",
+				name, len(var_.Values)))
+			c.EmitInitializers(var_.Values)
+			c.EmitRaw(";\n")
+		}
 
 	case parse.ExternVecInitNode:
 		vec := v.(parse.ExternVecInitNode)
-		c.EmitPartial(fmt.Sprintf("static B_AUTO %v[][%d] = ",
-			vec.Name, vec.Size))
 
-		c.StartBlock()
+		if len(vec.Values) == 0 {
+			return
+		}
 
-		for i, val := range vec.Values {
-			if i != len(vec.Values)-1 {
-				c.EmitLine(fmt.Sprintf("%v,", val))
-			} else {
-				c.EmitLine(val.String())
-			}
+		c.EmitPartial(fmt.Sprintf("static B_AUTO %s[%d] = ",
+			sanitizeIdentifier(vec.Name), vec.Size+1))
+		c.EmitInitializers(vec.Values)
+		c.EmitRaw(";\n")
+	}
+}
+
+func (c *CEmitter) EmitInitializers(values []parse.Node) {
+	c.EmitRaw("{ ")
+
+	for i, val := range values {
+		c.EmitInitializer(val)
+
+		if i != len(values)-1 {
+			c.EmitRaw(", ")
 		}
+	}
 
-		c.EndBlock()
+	c.EmitRaw(" }")
+}
 
+// A name as an initial value stores the address of that global, and a
+// string the address of its characters.
+func (c *CEmitter) EmitInitializer(val parse.Node) {
+	switch val.(type) {
+	case parse.IdentNode:
+		c.EmitRaw(fmt.Sprintf("(B_AUTO)&%s", sanitizeIdentifier(val.String())))
+	case parse.StringNode:
+		c.EmitRaw("(B_AUTO)")
+		c.EmitExpression(val)
+	default:
+		c.EmitExpression(val)
 	}
 }
 
@@ -108,7 +184,11 @@ func (c *CEmitter) EmitFunction(fn parse.FunctionNode) {
 	}
 	c.EmitRaw(") ")
 
+	c.labels = parse.FunctionLabels(fn)
+	c.extrns = parse.FunctionExtrns(fn)
 	c.EmitBlock(fn.Body.(parse.BlockNode))
+	c.labels = nil
+	c.extrns = nil
 }
 
 func (c *CEmitter) EmitBlock(block parse.BlockNode) {
@@ -127,10 +207,34 @@ func (c *CEmitter) EmitStatement(node parse.Node) {
 		c.EmitBlock(node.(parse.BlockNode))
 	case parse.BreakNode:
 		c.EmitLine("break;")
+	case parse.CaseLabelNode:
+		case_ := node.(parse.CaseLabelNode)
+
+		c.Deindent()
+
+		if case_.Default {
+			c.EmitLine("default:")
+		} else {
+			c.EmitPartial("case ")
+			c.EmitExpression(case_.Cond)
+			c.EmitRaw(":\n")
+		}
+
+		c.Indent()
 	case parse.ExternVarDeclNode:
 		c.EmitLine(fmt.Sprintf("/* %v */", node))
 	case parse.GotoNode:
-		c.EmitLine(fmt.Sprintf("goto %s;", node.(parse.GotoNode).Label))
+		goto_ := node.(parse.GotoNode)
+
+		// Anything but a jump straight to a label uses GCC's labels
+		// as values.
+		if name, ok := goto_.Label(); ok && c.isLabel(name) {
+			c.EmitLine(fmt.Sprintf("goto %s;", sanitizeIdentifier(name)))
+		} else {
+			c.EmitPartial("goto *(void *)(")
+			c.EmitExpression(goto_.Target)
+			c.EmitRaw(");\n")
+		}
 	case parse.IfNode:
 		if_ := node.(parse.IfNode)
 
@@ -160,7 +264,8 @@ func (c *CEmitter) EmitStatement(node parse.Node) {
 		}
 	case parse.LabelNode:
 		c.Deindent()
-		c.EmitLine(fmt.Sprintf("%s:", node.(parse.LabelNode).Name))
+		c.EmitLine(fmt.Sprintf("%s:",
+			sanitizeIdentifier(node.(parse.LabelNode).Name)))
 		c.Indent()
 	case parse.NullNode:
 		c.EmitLine(";")
@@ -177,27 +282,15 @@ func (c *CEmitter) EmitStatement(node parse.Node) {
 
 		c.EmitPartial("switch (")
 		c.EmitExpression(switch_.Cond)
-		c.EmitRaw(") {\n")
-
-		c.Indent()
-
-		for _, case_ := range switch_.Cases {
-			c.Deindent()
+		c.EmitRaw(")\n")
 
-			c.EmitLine(fmt.Sprintf("case %v:", case_.Cond))
+		if _, ok := switch_.Body.(parse.BlockNode); ok {
+			c.EmitStatement(switch_.Body)
+		} else {
 			c.Indent()
-			for _, stmt := range case_.Statements {
-				c.EmitStatement(stmt)
-			}
-		}
-
-		if switch_.DefaultCase != nil {
-			for _, stmt := range switch_.DefaultCase {
-				c.EmitStatement(stmt)
-			}
+			c.EmitStatement(switch_.Body)
+			c.Deindent()
 		}
-
-		c.EndBlock()
 	case parse.VarDeclNode:
 
 		c.EmitPartial("B_AUTO ")
@@ -205,8 +298,9 @@ func (c *CEmitter) EmitStatement(node parse.Node) {
 		for i, decl := range node.(parse.VarDeclNode).Vars {
 			c.EmitRaw(fmt.Sprintf("%s", decl.Name))
 
+			// Size is the highest index, as for external vectors
 			if decl.VecDecl {
-				c.EmitRaw(fmt.Sprintf("[%d]", decl.Size))
+				c.EmitRaw(fmt.Sprintf("[%d]", decl.Size+1))
 			}
 
 			if i != len(node.(parse.VarDeclNode).Vars)-1 {
@@ -252,9 +346,14 @@ func (c *CEmitter) EmitExpression(expr parse.Node) {
 
 	case parse.BinaryNode:
 		bin := expr.(parse.BinaryNode)
-		c.EmitExpression(bin.Left)
-		c.EmitRaw(" " + bin.Oper + " ")
-		c.EmitExpression(bin.Right)
+
+		if parse.IsAssignOp(bin.Oper) {
+			c.EmitAssignment(bin)
+		} else {
+			c.EmitExpression(bin.Left)
+			c.EmitRaw(" " + bin.Oper + " ")
+			c.EmitExpression(bin.Right)
+		}
 
 	case parse.IntegerNode:
 		c.EmitRaw(expr.String())
@@ -297,9 +396,24 @@ func (c *CEmitter) EmitExpression(expr parse.Node) {
 		}
 
 	case parse.IdentNode:
+		if c.isLabel(expr.String()) {
+			c.EmitRaw("(B_AUTO)&&")
+		}
+
 		c.EmitRaw(sanitizeIdentifier(expr.String()))
 
-	case parse.CharacterNode, parse.StringNode:
+		// Only a name declared extrn refers to the global, any other
+		// is local to the function.
+		if c.isArrayScalar(expr.String()) {
+			c.EmitRaw("[0]")
+		}
+
+	case parse.CharacterNode:
+		// Multi-character constants are packed into a word, so
+		// they can't be written as C character literals.
+		c.EmitRaw(fmt.Sprintf("%d", expr.(parse.CharacterNode).Value))
+
+	case parse.StringNode:
 		c.EmitRaw(escapeString(expr.String()))
 
 	default:
@@ -308,6 +422,41 @@ func (c *CEmitter) EmitExpression(expr parse.Node) {
 	}
 }
 
+// B writes compound assignment with the '=' first, C with it last. The
+// relational forms (===, =<, ...) have no C equivalent, so they go
+// through a pointer to the left hand side, using GCC's statement
+// expressions, so that it's still only evaluated once.
+func (c *CEmitter) EmitAssignment(bin parse.BinaryNode) {
+	switch op := bin.Oper[1:]; op {
+	case "":
+		c.EmitExpression(bin.Left)
+		c.EmitRaw(" = ")
+		c.EmitExpression(bin.Right)
+
+	case "+", "-", "*", "/", "%", "<<", ">>", "&", "|", "^":
+		c.EmitExpression(bin.Left)
+		c.EmitRaw(" " + op + "= ")
+		c.EmitExpression(bin.Right)
+
+	default:
+		c.EmitRaw("({ B_AUTO *__lhs = &(")
+		c.EmitExpression(bin.Left)
+		c.EmitRaw("); *__lhs = *__lhs " + op + " (")
+		c.EmitExpression(bin.Right)
+		c.EmitRaw("); })")
+	}
+}
+
+func (c *CEmitter) isLabel(name string) bool {
+	_, ok := c.labels[name]
+	return ok
+}
+
+func (c *CEmitter) isArrayScalar(name string) bool {
+	_, ok := c.extrns[name]
+	return ok && c.arrays[name]
+}
+
 func (c *CEmitter) EmitRaw(text string) {
 	c.writer.WriteString(text)
 }
@@ -0,0 +1,274 @@
+package emit
+
+import (
+	"bytes"
+	"github.com/erik/gob/parse"
+	"io/ioutil"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func emitC(t *testing.T, src string) string {
+	unit, err := parse.NewParser("test.b", strings.NewReader(src)).Parse()
+	if err != nil {
+		t.Fatalf("Parse failed: %v", err)
+	}
+
+	var buf bytes.Buffer
+	var c CEmitter
+
+	if err = c.Emit(&buf, unit); err != nil {
+		t.Fatalf("Emit failed: %v", err)
+	}
+
+	return buf.String()
+}
+
+// Compile the C emitted for src along with main, a C main function,
+// run it and return its exit status. Skipped where there's no GCC.
+func runC(t *testing.T, src, main string) int {
+	gcc, err := exec.LookPath("gcc")
+	if err != nil {
+		t.Skip("gcc not found")
+	}
+
+	dir, err := ioutil.TempDir("", "gob")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	files := map[string]string{
+		"bstdlib.h": "#include <stdint.h>\ntypedef intptr_t B_AUTO;\n",
+		"test.c":    emitC(t, src) + "\n" + main + "\n",
+	}
+
+	for name, text := range files {
+		path := filepath.Join(dir, name)
+		if err := ioutil.WriteFile(path, []byte(text), 0644); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	bin := filepath.Join(dir, "test")
+	out, err := exec.Command(gcc, "-std=gnu99", "-w", "-o", bin,
+		filepath.Join(dir, "test.c")).CombinedOutput()
+	if err != nil {
+		t.Fatalf("gcc failed: %v\n%s\n%s", err, out, files["test.c"])
+	}
+
+	err = exec.Command(bin).Run()
+	if exit, ok := err.(*exec.ExitError); ok {
+		return exit.ExitCode()
+	} else if err != nil {
+		t.Fatal(err)
+	}
+
+	return 0
+}
+
+func TestEmitAssignmentOps(t *testing.T) {
+	out := emitC(t, `
+main() {
+  auto a, b;
+  a =+ 1; a =- b; a =* 2; a =/ 2; a =% 3; a =<< 1; a =>> 1;
+  a =& b; a =| b; a =^ b;
+  a === b; a =!= b; a =< b; a =<= b; a => b; a =>= b;
+}`)
+
+	var expect = []string{
+		"a += 1;", "a -= b;", "a *= 2;", "a /= 2;", "a %= 3;",
+		"a <<= 1;", "a >>= 1;", "a &= b;", "a |= b;", "a ^= b;",
+		"({ B_AUTO *__lhs = &(a); *__lhs = *__lhs == (b); });",
+		"({ B_AUTO *__lhs = &(a); *__lhs = *__lhs != (b); });",
+		"({ B_AUTO *__lhs = &(a); *__lhs = *__lhs < (b); });",
+		"({ B_AUTO *__lhs = &(a); *__lhs = *__lhs <= (b); });",
+		"({ B_AUTO *__lhs = &(a); *__lhs = *__lhs > (b); });",
+		"({ B_AUTO *__lhs = &(a); *__lhs = *__lhs >= (b); });",
+	}
+
+	for _, str := range expect {
+		if !strings.Contains(out, str) {
+			t.Errorf("Missing <%s> in output:\n%s", str, out)
+		}
+	}
+}
+
+// The left hand side of a relational assignment is evaluated once.
+func TestEmitAssignmentSideEffects(t *testing.T) {
+	status := runC(t, `
+f() {
+  auto v[1], i;
+  v[0] = 5;
+  i = 0;
+  v[i++] =< 7;
+  return (i * 10 + v[0]);
+}`, "int main(void) { return f(); }")
+
+	if status != 11 {
+		t.Errorf("Expected 11, got %d", status)
+	}
+}
+
+func TestEmitCharacter(t *testing.T) {
+	out := emitC(t, `
+c 'ab';
+main() {
+  auto x;
+  switch (x) { case '*e': x = 'a'; }
+}`)
+
+	for _, str := range []string{"c = 25185;", "case 4:", "x = 97;"} {
+		if !strings.Contains(out, str) {
+			t.Errorf("Missing <%s> in output:\n%s", str, out)
+		}
+	}
+}
+
+func TestEmitSwitch(t *testing.T) {
+	out := emitC(t, `
+copy(to, from, n) {
+  switch (n % 4) while (n > 0) {
+    case 0: *to++ = *from++;
+    case 3: *to++ = *from++;
+    case 2: *to++ = *from++;
+    case 1: *to++ = *from++;
+    n =- 4;
+  }
+}`)
+
+	expect := `	switch (n % 4)
+		while (n > 0)
+		{
+		case 0:
+			*to++ = *from++;
+		case 3:
+			*to++ = *from++;
+		case 2:
+			*to++ = *from++;
+		case 1:
+			*to++ = *from++;
+			n -= 4;
+		}
+`
+
+	if !strings.Contains(out, expect) {
+		t.Errorf("Expected:\n%s\nin output:\n%s", expect, out)
+	}
+}
+
+func TestEmitGlobals(t *testing.T) {
+	out := emitC(t, `
+a;
+b 1;
+c 1, 2, main;
+s "str";
+d[10];
+e[] a, b;
+main() {}
+`)
+
+	var expect = []string{
+		"static B_AUTO main();",
+		"static B_AUTO a;",
+		"static B_AUTO b;",
+		"static B_AUTO c[3];",
+		"static B_AUTO d[11];",
+		"static B_AUTO e[2];",
+		"static B_AUTO b = 1;",
+		"static B_AUTO c[3] = { 1, 2, (B_AUTO)&main };",
+		"static B_AUTO s = (B_AUTO)\"str\";",
+		"static B_AUTO e[2] = { (B_AUTO)&a, (B_AUTO)&b };",
+	}
+
+	for _, str := range expect {
+		if !strings.Contains(out, str) {
+			t.Errorf("Missing <%s> in output:\n%s", str, out)
+		}
+	}
+
+	// Prototypes precede the globals which refer to them
+	if strings.Index(out, "main();") > strings.Index(out, "&main") {
+		t.Errorf("Prototype after use:\n%s", out)
+	}
+}
+
+// auto k[4] holds five words, k[0] to k[4].
+func TestEmitAutoVector(t *testing.T) {
+	src := `
+f() {
+  auto i, k[4], j;
+  i = 0;
+  j = 7;
+  while (i <= 4) { k[i] = i; i++; }
+  return (j * 10 + k[4]);
+}`
+
+	out := emitC(t, src)
+	if !strings.Contains(out, "B_AUTO i, k[5], j;") {
+		t.Errorf("Missing <B_AUTO i, k[5], j;> in output:\n%s", out)
+	}
+
+	status := runC(t, src, "int main(void) { return f(); }")
+	if status != 74 {
+		t.Errorf("Expected 74, got %d", status)
+	}
+}
+
+// A scalar with several values is only its first word where it's named
+// extrn: parameters and autos of the same name are unaffected.
+func TestEmitArrayScalar(t *testing.T) {
+	src := `
+c 1, 2;
+f(c) { return (c); }
+g() { auto c; c = 7; return (c); }
+h() { extrn c; c =+ 10; return (c); }
+`
+
+	out := emitC(t, src)
+
+	for _, str := range []string{"return (c);", "c = 7;", "c[0] += 10;",
+		"return (c[0]);"} {
+
+		if !strings.Contains(out, str) {
+			t.Errorf("Missing <%s> in output:\n%s", str, out)
+		}
+	}
+
+	status := runC(t, src, "int main(void) { return f(3) + g() + h(); }")
+	if status != 21 {
+		t.Errorf("Expected 21, got %d", status)
+	}
+}
+
+func TestEmitComputedGoto(t *testing.T) {
+	out := emitC(t, `
+f(i) {
+  auto states[1], next;
+  states[0] = one;
+  states[1] = two;
+  next = two;
+  goto states[i];
+one:
+  goto next;
+two:
+  goto one;
+}`)
+
+	var expect = []string{
+		"states[0] = (B_AUTO)&&one;",
+		"next = (B_AUTO)&&two;",
+		"goto *(void *)(states[i]);",
+		"goto *(void *)(next);",
+		"goto one;",
+	}
+
+	for _, str := range expect {
+		if !strings.Contains(out, str) {
+			t.Errorf("Missing <%s> in output:\n%s", str, out)
+		}
+	}
+}
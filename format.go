@@ -0,0 +1,101 @@
+package main
+
+import (
+	"bytes"
+	"fmt"
+	"github.com/erik/gob/printer"
+	"io/ioutil"
+	"os"
+	"os/exec"
+)
+
+// `gob fmt [-w | -d] file...`: print each file in canonical form, or
+// with -w rewrite the ones which aren't, or with -d show what would
+// change.
+func formatFiles(files []string) {
+	if len(files) < 1 {
+		fmt.Println("Need to specify an input file")
+		return
+	}
+
+	for _, name := range files {
+		src, err := ioutil.ReadFile(name)
+		if err != nil {
+			fmt.Println(err)
+			os.Exit(1)
+		}
+
+		out, err := printer.Source(name, src)
+		if err != nil {
+			fmt.Fprintln(os.Stderr, err)
+			continue
+		}
+
+		switch {
+		case *diffOut:
+			if bytes.Equal(src, out) {
+				continue
+			}
+
+			data, err := textDiff(name, src, out)
+			if err != nil {
+				fmt.Fprintln(os.Stderr, err)
+				os.Exit(1)
+			}
+
+			os.Stdout.Write(data)
+
+		case *writeOut:
+			if bytes.Equal(src, out) {
+				continue
+			}
+
+			info, err := os.Stat(name)
+			if err != nil {
+				fmt.Println(err)
+				os.Exit(1)
+			}
+
+			err = ioutil.WriteFile(name, out, info.Mode().Perm())
+			if err != nil {
+				fmt.Println(err)
+				os.Exit(1)
+			}
+
+		default:
+			os.Stdout.Write(out)
+		}
+	}
+}
+
+// A unified diff from the system's diff, as gofmt -d does it.
+func textDiff(name string, a, b []byte) ([]byte, error) {
+	var files [2]string
+
+	for i, data := range [][]byte{a, b} {
+		f, err := ioutil.TempFile("", "gob")
+		if err != nil {
+			return nil, err
+		}
+
+		files[i] = f.Name()
+		defer os.Remove(f.Name())
+
+		_, err = f.Write(data)
+		f.Close()
+
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	data, err := exec.Command("diff", "-u", "-L", name+".orig", "-L", name,
+		files[0], files[1]).CombinedOutput()
+
+	// diff exits with 1 when the files differ
+	if len(data) > 0 {
+		err = nil
+	}
+
+	return data, err
+}
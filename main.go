@@ -17,6 +17,16 @@ var (
 	parseOnly = opt.Flag([]string{"-p", "--parse-only"}, []string{},
 		"Don't output anything, just parse", "")
 	outFile = opt.String([]string{"-o"}, "", "Name of output file")
+	jsonOut = opt.Flag([]string{"--json"}, []string{},
+		"Print machine readable output (tokens, ast)", "")
+	sexpOut = opt.Flag([]string{"--sexp"}, []string{},
+		"Print the syntax tree as an S-expression (ast)", "")
+	funcName = opt.String([]string{"--func"}, "",
+		"Only print the named function (ast)")
+	writeOut = opt.Flag([]string{"-w"}, []string{},
+		"Write the result back to the source file (fmt)", "")
+	diffOut = opt.Flag([]string{"-d"}, []string{},
+		"Print a diff instead of the formatted source (fmt)", "")
 )
 
 func main() {
@@ -27,13 +37,36 @@ func main() {
 		return
 	}
 
-	if len(opt.Args) < 1 {
+	args := opt.Args
+
+	if len(args) > 0 {
+		switch args[0] {
+		case "tokens":
+			dumpTokens(args[1:])
+			return
+		case "ast":
+			dumpAST(args[1:])
+			return
+		case "fmt":
+			formatFiles(args[1:])
+			return
+		case "diff":
+			diffFiles(args[1:])
+			return
+		}
+	}
+
+	compile(args)
+}
+
+func compile(files []string) {
+	if len(files) < 1 {
 		fmt.Println("Need to specify an input file")
 		return
 	}
 
-	for _, name := range opt.Args {
-		if len(opt.Args) > 1 {
+	for _, name := range files {
+		if len(files) > 1 {
 			fmt.Printf("==== %s ====\n", name)
 		}
 
@@ -45,9 +78,12 @@ func main() {
 
 		parser := parse.NewParser(name, file)
 
+		// Every syntax error in the file is reported at once. The unit
+		// is incomplete, so there's no point in checking or emitting it.
 		unit, err := parser.Parse()
 		if err != nil {
 			fmt.Println(err)
+			continue
 		}
 
 		if err = unit.Verify(); err != nil {
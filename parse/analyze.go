@@ -3,6 +3,7 @@ package parse
 import (
 	"fmt"
 	"reflect"
+	"text/scanner"
 )
 
 type SemanticError struct {
@@ -11,9 +12,12 @@ type SemanticError struct {
 }
 
 func (s *SemanticError) Error() string {
-	return fmt.Sprintf("Semantic error on `%v`: %v", s.node, s.msg)
+	return fmt.Sprintf("%v: Semantic error on `%v`: %v",
+		s.node.Pos(), s.node, s.msg)
 }
 
+func (s *SemanticError) Pos() scanner.Position { return s.node.Pos() }
+
 func NewSemanticError(node Node, msg string) error {
 	return &SemanticError{node, msg}
 }
@@ -22,6 +26,8 @@ type TranslationUnit struct {
 	File  string
 	Funcs []FunctionNode
 	Vars  []Node
+	Bad   []BadNode // top level declarations which failed to parse
+	Defs  []Node    // all of the above, in source order, if known
 }
 
 func (t TranslationUnit) String() string {
@@ -40,12 +46,37 @@ func (t TranslationUnit) String() string {
 	return str
 }
 
+// The top level declarations in source order. A unit put together
+// without Defs gives its externals, then its functions, then anything
+// which failed to parse.
+func (t TranslationUnit) Definitions() []Node {
+	if len(t.Defs) > 0 {
+		return t.Defs
+	}
+
+	defs := append([]Node{}, t.Vars...)
+
+	for _, fn := range t.Funcs {
+		defs = append(defs, fn)
+	}
+
+	for _, bad := range t.Bad {
+		defs = append(defs, bad)
+	}
+
+	return defs
+}
+
 func (t TranslationUnit) Verify() error {
 
 	if err := t.ResolveDuplicates(); err != nil {
 		return err
 	}
 
+	if err := t.VerifyGlobals(); err != nil {
+		return err
+	}
+
 	for _, fn := range t.Funcs {
 
 		if err := t.VerifyFunction(fn); err != nil {
@@ -59,6 +90,16 @@ func (t TranslationUnit) Verify() error {
 		if err := t.ResolveLabels(fn); err != nil {
 			return err
 		}
+
+		if err := t.ResolveCases(fn); err != nil {
+			return err
+		}
+	}
+
+	// Names only need declaring here, so trees without positions to
+	// bind them by can be verified too
+	if errs := t.resolve(nil); len(errs) > 0 {
+		return errs
 	}
 
 	return nil
@@ -101,72 +142,28 @@ func (t TranslationUnit) expectNodeType(node Node, kind reflect.Type) error {
 	return nil
 }
 
+// Call visit on every expression under node, outermost first.
 func (t TranslationUnit) visitExpressions(node Node, visit func(Node) error) error {
-	if IsExpr(node) {
-		return visit(node)
-	}
-
-	switch node.(type) {
-	case BlockNode:
-		for _, n := range node.(BlockNode).Nodes {
-			if err := t.visitExpressions(n, visit); err != nil {
-				return err
-			}
-		}
-	case FunctionNode:
-		if err := t.visitExpressions(node.(FunctionNode).Body, visit); err != nil {
-			return err
-		}
-
-	case IfNode:
-		if err := visit(node.(IfNode).Cond); err != nil {
-			return err
-		}
-
-		if err := t.visitExpressions(node.(IfNode).Body, visit); err != nil {
-			return err
-		}
-
-		if node.(IfNode).HasElse {
-			if err := t.visitExpressions(node.(IfNode).ElseBody, visit); err != nil {
-				return err
-			}
-		}
-
-	case SwitchNode:
-		if err := visit(node.(SwitchNode).Cond); err != nil {
-			return err
-		}
-
-		for _, stmt := range node.(SwitchNode).DefaultCase {
-			if err := t.visitExpressions(stmt, visit); err != nil {
-				return err
-			}
-		}
-
-		for _, case_ := range node.(SwitchNode).Cases {
-			if err := visit(case_.Cond); err != nil {
-				return err
-			}
+	var err error
 
-			if err := t.visitExpressions(case_, visit); err != nil {
-				return err
-			}
+	Inspect(node, func(n Node) bool {
+		if err != nil || n == nil {
+			return false
 		}
 
-	case WhileNode:
-		if err := visit(node.(WhileNode).Cond); err != nil {
-			return err
+		if IsExpr(n) {
+			err = visit(n)
 		}
 
-		if err := t.visitExpressions(node.(WhileNode).Body, visit); err != nil {
-			return err
-		}
-	}
+		return err == nil
+	})
 
-	return nil
+	return err
 }
 
+// Call visit on every statement under node, other than those which only
+// group others (blocks, if, while and the function itself), checking
+// that there's a statement wherever one is needed.
 func (t TranslationUnit) visitStatements(node Node, visit func(Node) error) error {
 
 	if err := t.expectStatement(node); err != nil {
@@ -174,64 +171,25 @@ func (t TranslationUnit) visitStatements(node Node, visit func(Node) error) erro
 	}
 
 	switch node.(type) {
-	case BlockNode:
-		for _, n := range node.(BlockNode).Nodes {
-			if err := t.expectStatement(n); err != nil {
-				return err
-			}
-
-			if err := t.visitStatements(n, visit); err != nil {
-				return err
-			}
-		}
-	case FunctionNode:
-		if err := t.expectStatement(node.(FunctionNode).Body); err != nil {
-			return err
-		}
-
-		if err := t.visitStatements(node.(FunctionNode).Body, visit); err != nil {
-			return err
-		}
-
-	case GotoNode:
-		if err := visit(node); err != nil {
-			return err
-		}
-
-	case IfNode:
-		if err := t.visitStatements(node.(IfNode).Body, visit); err != nil {
-			return err
-		}
-
-		if node.(IfNode).HasElse {
-			if err := t.visitStatements(node.(IfNode).ElseBody, visit); err != nil {
-				return err
-			}
-		}
-
-	case BreakNode, ExternVarDeclNode, ExternVarInitNode,
-		ExternVecInitNode, LabelNode, ReturnNode, StatementNode, VarDeclNode:
+	case BlockNode, FunctionNode, IfNode, WhileNode:
+	default:
 		if err := visit(node); err != nil {
 			return err
 		}
-	case SwitchNode:
+	}
 
-		for _, stmt := range node.(SwitchNode).DefaultCase {
-			if err := t.visitStatements(stmt, visit); err != nil {
-				return err
+	switch node.(type) {
+	case BlockNode, FunctionNode, IfNode, SwitchNode, WhileNode:
+		for _, child := range Children(node) {
+			// Conditions
+			if IsExpr(child) {
+				continue
 			}
-		}
 
-		for _, case_ := range node.(SwitchNode).Cases {
-			if err := t.visitStatements(case_, visit); err != nil {
+			if err := t.visitStatements(child, visit); err != nil {
 				return err
 			}
 		}
-
-	case WhileNode:
-		if err := t.visitStatements(node.(WhileNode).Body, visit); err != nil {
-			return err
-		}
 	}
 
 	return nil
@@ -274,7 +232,7 @@ func (t TranslationUnit) VerifyAssignments(fn FunctionNode) error {
 			return nil
 		}
 		if bin, ok := stmt.Expr.(BinaryNode); ok {
-			if bin.Oper == "=" {
+			if IsAssignOp(bin.Oper) {
 				if err := t.expectLHS(bin.Left); err != nil {
 					return err
 				}
@@ -290,7 +248,7 @@ func (t TranslationUnit) VerifyAssignments(fn FunctionNode) error {
 	return t.visitStatements(fn.Body, visit)
 }
 
-// TODO: resolve auto variable declarations within function definitions
+// Check no global name, and no name within a function, is defined twice.
 func (t TranslationUnit) ResolveDuplicates() error {
 	idents := map[string]Node{}
 
@@ -321,14 +279,82 @@ func (t TranslationUnit) ResolveDuplicates() error {
 		idents[name] = v
 	}
 
+	for _, fn := range t.Funcs {
+		if err := t.resolveLocalDuplicates(fn); err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+// Check external definitions: a vector can't have more values than it
+// has words, and names used as values must be defined globally.
+func (t TranslationUnit) VerifyGlobals() error {
+	globals := map[string]bool{}
+
+	for _, fn := range t.Funcs {
+		globals[fn.Name] = true
+	}
+
+	for _, v := range t.Vars {
+		switch v.(type) {
+		case ExternVarInitNode:
+			globals[v.(ExternVarInitNode).Name] = true
+		case ExternVecInitNode:
+			globals[v.(ExternVecInitNode).Name] = true
+		}
+	}
+
+	for _, v := range t.Vars {
+		var values []Node
+
+		switch v.(type) {
+		case ExternVarInitNode:
+			values = v.(ExternVarInitNode).Values
+
+		case ExternVecInitNode:
+			vec := v.(ExternVecInitNode)
+			values = vec.Values
+
+			if len(vec.Values) > vec.Size+1 {
+				return NewSemanticError(v, fmt.Sprintf(
+					"%d values given for vector of size %d",
+					len(vec.Values), vec.Size))
+			}
+		}
+
+		for _, val := range values {
+			if ident, ok := val.(IdentNode); ok && !globals[ident.Value] {
+				return NewSemanticError(val, "undefined name in initializer")
+			}
+		}
+	}
+
 	return nil
 }
 
-// Make sure all goto jump to valid places
+// Make sure all goto jump to valid places. A goto through a name which
+// isn't a label must be through a variable, which is trusted to hold
+// one at run time.
 func (t TranslationUnit) ResolveLabels(fn FunctionNode) error {
 	labels := map[string]bool{}
+	vars := map[string]bool{}
 	gotos := []GotoNode{}
 
+	for _, param := range fn.Params {
+		vars[param] = true
+	}
+
+	for _, v := range t.Vars {
+		switch v.(type) {
+		case ExternVarInitNode:
+			vars[v.(ExternVarInitNode).Name] = true
+		case ExternVecInitNode:
+			vars[v.(ExternVecInitNode).Name] = true
+		}
+	}
+
 	visiter := func(node Node) error {
 		switch node.(type) {
 		case LabelNode:
@@ -338,6 +364,14 @@ func (t TranslationUnit) ResolveLabels(fn FunctionNode) error {
 			labels[node.(LabelNode).Name] = true
 		case GotoNode:
 			gotos = append(gotos, node.(GotoNode))
+		case VarDeclNode:
+			for _, decl := range node.(VarDeclNode).Vars {
+				vars[decl.Name] = true
+			}
+		case ExternVarDeclNode:
+			for _, name := range node.(ExternVarDeclNode).Names {
+				vars[name] = true
+			}
 		}
 		return nil
 	}
@@ -347,10 +381,113 @@ func (t TranslationUnit) ResolveLabels(fn FunctionNode) error {
 	}
 
 	for _, node := range gotos {
-		if _, ok := labels[node.Label]; !ok {
-			return NewSemanticError(node, "unresolved goto")
+		if name, ok := node.Label(); ok {
+			if !labels[name] && !vars[name] {
+				return NewSemanticError(node, "unresolved goto")
+			}
+		} else if err := t.expectRHS(node.Target); err != nil {
+			return err
 		}
 	}
 
 	return nil
 }
+
+// The labels defined in a function, which may be used as values.
+func FunctionLabels(fn FunctionNode) map[string]LabelNode {
+	labels := map[string]LabelNode{}
+
+	TranslationUnit{}.visitStatements(fn, func(node Node) error {
+		if label, ok := node.(LabelNode); ok {
+			labels[label.Name] = label
+		}
+		return nil
+	})
+
+	return labels
+}
+
+// The names a function declares extrn, with their declarations.
+func FunctionExtrns(fn FunctionNode) map[string]ExternVarDeclNode {
+	extrns := map[string]ExternVarDeclNode{}
+
+	TranslationUnit{}.visitStatements(fn, func(node Node) error {
+		if decl, ok := node.(ExternVarDeclNode); ok {
+			for _, name := range decl.Names {
+				extrns[name] = decl
+			}
+		}
+		return nil
+	})
+
+	return extrns
+}
+
+// The case labels belonging to a switch statement: those in its body
+// which aren't inside a nested switch.
+func SwitchCases(sw SwitchNode) []CaseLabelNode {
+	var cases []CaseLabelNode
+
+	Inspect(sw.Body, func(node Node) bool {
+		switch node.(type) {
+		case CaseLabelNode:
+			cases = append(cases, node.(CaseLabelNode))
+			return false
+		case SwitchNode:
+			return false
+		}
+
+		return node != nil && !IsExpr(node)
+	})
+
+	return cases
+}
+
+// Make sure every case label is inside a switch, and that no switch has
+// two labels for the same case.
+func (t TranslationUnit) ResolveCases(fn FunctionNode) error {
+	visiter := func(node Node) error {
+		sw, ok := node.(SwitchNode)
+		if !ok {
+			return nil
+		}
+
+		seen := map[string]bool{}
+
+		for _, label := range SwitchCases(sw) {
+			key := "default"
+			if !label.Default {
+				key = fmt.Sprint(label.Value)
+			}
+
+			if seen[key] {
+				return NewSemanticError(label, "duplicate case in switch")
+			}
+			seen[key] = true
+		}
+		return nil
+	}
+
+	if err := t.visitStatements(fn, visiter); err != nil {
+		return err
+	}
+
+	// Any label reached without going through a switch is outside one
+	var err error
+
+	Inspect(fn.Body, func(node Node) bool {
+		switch node.(type) {
+		case CaseLabelNode:
+			if err == nil {
+				err = NewSemanticError(node, "case label not within a switch")
+			}
+			return false
+		case SwitchNode:
+			return false
+		}
+
+		return err == nil && node != nil && !IsExpr(node)
+	})
+
+	return err
+}
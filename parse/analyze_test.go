@@ -34,19 +34,46 @@ func TestAnalyzeDuplicate(t *testing.T) {
 	} else if err = unit.ResolveDuplicates(); err == nil {
 		t.Errorf("Allowed duplicate func/variable")
 	}
+
+	// Parameters, autos, extrns and labels share a scope
+	var locals = []struct {
+		src string
+		ok  bool
+	}{
+		{"f(a, b) { auto c; extrn d; e: ; }", true},
+		{"f(a) { b: ; } g(b) { auto a; }", true},
+		{"f(a) { a: ; }", false},
+		{"f(a, a) ;", false},
+		{"f(a) { auto a; }", false},
+		{"f() { auto a, b, a; }", false},
+		{"f() { auto a; extrn a; }", false},
+		{"f() { extrn a; a: ; }", false},
+		{"f() { a: ; { a: ; } }", false},
+	}
+
+	for _, test := range locals {
+		unit, err := NewParser("", strings.NewReader(test.src)).Parse()
+		if err != nil {
+			t.Errorf("%s: parse failed: %v", test.src, err)
+		} else if err = unit.ResolveDuplicates(); (err == nil) != test.ok {
+			t.Errorf("%s: expected ok = %v, got %v", test.src, test.ok, err)
+		}
+	}
 }
 
 func TestLHS(t *testing.T) {
 	var unit TranslationUnit
 
 	// Simple lhs cases
-	if err := unit.expectLHS(IdentNode{"foo"}); err != nil {
+	if err := unit.expectLHS(IdentNode{Value: "foo"}); err != nil {
 		t.Errorf("ident node LHS")
 	}
-	if err := unit.expectLHS(ArrayAccessNode{IdentNode{"abc"}, IntegerNode{2}}); err != nil {
+	if err := unit.expectLHS(ArrayAccessNode{Array: IdentNode{Value: "abc"},
+		Index: IntegerNode{Value: 2}}); err != nil {
 		t.Errorf("array access lhs")
 	}
-	if err := unit.expectLHS(UnaryNode{"*", IntegerNode{1}, false}); err != nil {
+	if err := unit.expectLHS(UnaryNode{Oper: "*",
+		Node: IntegerNode{Value: 1}}); err != nil {
 		t.Errorf("unary node lhs")
 	}
 }
@@ -65,6 +92,125 @@ bad() { 1 = a; 'this' = 'that';}`)).Parse()
 	} else if err = unit.VerifyAssignments(unit.Funcs[0]); err != nil {
 		t.Errorf("verify good assignments failed: %v", err)
 	} else if err = unit.VerifyAssignments(unit.Funcs[1]); err == nil {
-		t.Errorf("verify bad assignements passed", err)
+		t.Errorf("verify bad assignements passed")
+	}
+}
+
+func TestResolveCases(t *testing.T) {
+	var tests = []struct {
+		src string
+		ok  bool
+	}{
+		{"f(x) { switch (x) { case 1: case 2: default: ; } }", true},
+		{"f(x) { switch (x) while (x) { case 1: x--; } }", true},
+		{"f(x) { switch (x) { case 1: switch (x) { case 1: ; } } }", true},
+		{"f(x) { case 1: x = 2; }", false},
+		{"f(x) { if (x) { default: ; } }", false},
+		{"f(x) { switch (x) { case 1: case 2: case 1: ; } }", false},
+		{"f(x) { switch (x) { case 97: case 'a': ; } }", false},
+		{"f(x) { switch (x) { default: x++; default: ; } }", false},
+	}
+
+	for _, test := range tests {
+		unit, err := NewParser("", strings.NewReader(test.src)).Parse()
+		if err != nil {
+			t.Errorf("Parse failed: %v", err)
+			continue
+		}
+
+		err = unit.ResolveCases(unit.Funcs[0])
+		if test.ok && err != nil {
+			t.Errorf("%s: %v", test.src, err)
+		} else if !test.ok && err == nil {
+			t.Errorf("%s: expected error", test.src)
+		}
+	}
+}
+
+// Without positions, labels can only be told apart by where they are.
+func TestResolveCasesWithoutPositions(t *testing.T) {
+	one := CaseLabelNode{Cond: IntegerNode{Value: 1, Radix: 10}, Value: 1}
+
+	fn := FunctionNode{Name: "f", Params: []string{"x"},
+		Body: BlockNode{Nodes: []Node{
+			SwitchNode{Cond: IdentNode{Value: "x"},
+				Body: BlockNode{Nodes: []Node{one, NullNode{}}}},
+			one,
+			NullNode{},
+		}},
+	}
+
+	unit := TranslationUnit{Funcs: []FunctionNode{fn}}
+
+	if err := unit.ResolveCases(fn); err == nil {
+		t.Errorf("Expected an error for the case outside the switch")
+	}
+
+	if err := unit.Verify(); err == nil {
+		t.Errorf("Verify passed with a case outside a switch")
+	}
+}
+
+func TestVerifyGlobals(t *testing.T) {
+	var tests = []struct {
+		src string
+		ok  bool
+	}{
+		{"v[2] 1, 2, 3;", true},
+		{"v[2] 1, 2, 3, 4;", false},
+		{"v[] 1, 2, 3, 4;", true},
+		{"p f; f() {}", true},
+		{"p v; v[3];", true},
+		{"p q;", false},
+		{"v[1] a, b; a; b;", true},
+		{"v[1] a, c; a;", false},
+	}
+
+	for _, test := range tests {
+		unit, err := NewParser("", strings.NewReader(test.src)).Parse()
+		if err != nil {
+			t.Errorf("Parse failed: %v", err)
+			continue
+		}
+
+		err = unit.VerifyGlobals()
+		if test.ok && err != nil {
+			t.Errorf("%s: %v", test.src, err)
+		} else if !test.ok && err == nil {
+			t.Errorf("%s: expected error", test.src)
+		}
+	}
+}
+
+func TestResolveLabels(t *testing.T) {
+	var tests = []struct {
+		src string
+		ok  bool
+	}{
+		{"f() { a: goto a; }", true},
+		{"f() { goto a; a: ; }", true},
+		{"f() { goto a; }", false},
+		{"f() { a: a: ; }", false},
+		{"f() { auto s; a: s = a; goto s; }", true},
+		{"f(s) { goto s; }", true},
+		{"s; f() { goto s; }", true},
+		{"f() { extrn s; goto s; }", true},
+		{"f() { auto v[2]; a: b: v[0] = a; v[1] = b; goto v[x]; }", true},
+		{"f(x) { a: b: goto x ? a : b; }", true},
+	}
+
+	for _, test := range tests {
+		unit, err := NewParser("", strings.NewReader(test.src)).Parse()
+		if err != nil {
+			t.Errorf("Parse failed: %v", err)
+			continue
+		}
+
+		err = unit.ResolveLabels(unit.Funcs[len(unit.Funcs)-1])
+		if test.ok && err != nil {
+			t.Errorf("%s: %v", test.src, err)
+		} else if !test.ok && err == nil {
+			t.Errorf("%s: expected error", test.src)
+		}
 	}
 }
@@ -2,13 +2,27 @@ package parse
 
 import (
 	"fmt"
+	"strconv"
 	"strings"
+	"text/scanner"
 )
 
 type Node interface {
 	String() string
+	Pos() scanner.Position // first character belonging to the node
+	End() scanner.Position // first character after the node
 }
 
+// Span records where in the source file a node came from. Every node
+// embeds one, so positions are available on any Node.
+type Span struct {
+	Start scanner.Position
+	Stop  scanner.Position
+}
+
+func (s Span) Pos() scanner.Position { return s.Start }
+func (s Span) End() scanner.Position { return s.Stop }
+
 func IsExpr(n Node) bool {
 	switch n.(type) {
 	case ArrayAccessNode, BinaryNode, IdentNode, IntegerNode, CharacterNode,
@@ -24,7 +38,7 @@ func IsStatement(n Node) bool {
 	}
 
 	switch n.(type) {
-	case BlockNode, BreakNode, CaseNode, ExternVarDeclNode,
+	case BadNode, BlockNode, BreakNode, CaseLabelNode, ExternVarDeclNode,
 		ExternVarInitNode, ExternVecInitNode, FunctionNode, GotoNode,
 		IfNode, LabelNode, NullNode, ReturnNode, StatementNode, SwitchNode,
 		VarDeclNode, WhileNode:
@@ -35,6 +49,7 @@ func IsStatement(n Node) bool {
 }
 
 type ArrayAccessNode struct {
+	Span
 	Array Node
 	Index Node
 }
@@ -43,7 +58,13 @@ func (a ArrayAccessNode) String() string {
 	return fmt.Sprintf("%s[%s]", a.Array, a.Index)
 }
 
+// Stands in for source which failed to parse
+type BadNode struct{ Span }
+
+func (b BadNode) String() string { return "<bad>" }
+
 type BinaryNode struct {
+	Span
 	Left  Node
 	Oper  string
 	Right Node
@@ -76,6 +97,7 @@ func (b BinaryNode) StringWithPrecedence() string {
 
 // '{' node* '}'
 type BlockNode struct {
+	Span
 	Nodes []Node
 }
 
@@ -90,54 +112,85 @@ func (b BlockNode) String() string {
 	return str
 }
 
-type BreakNode struct{}
+type BreakNode struct{ Span }
 
 func (b BreakNode) String() string { return "break;" }
 
+// Up to a word's worth of characters packed into a single value
 type CharacterNode struct {
-	value string
+	Span
+	Text  string // as written, escapes included
+	Value int64
 }
 
-func (c CharacterNode) String() string { return fmt.Sprintf("'%s'", c.value) }
+func (c CharacterNode) String() string { return fmt.Sprintf("'%s'", c.Text) }
 
 type ExternVarDeclNode struct {
-	names []string
+	Span
+	Names []string
 }
 
 func (e ExternVarDeclNode) String() string {
-	return fmt.Sprintf("extrn %s;", strings.Join(e.names, ", "))
+	return fmt.Sprintf("extrn %s;", strings.Join(e.Names, ", "))
 }
 
-// name value ';'
+// name (value (',' value)*)? ';'
+//
+// A scalar with several values takes up consecutive words. With no
+// values at all, it is zero.
 type ExternVarInitNode struct {
-	Name  string
-	Value Node
+	Span
+	Name   string
+	Values []Node
 }
 
 func (e ExternVarInitNode) String() string {
-	return fmt.Sprintf("%s %v;", e.Name, e.Value)
+	if len(e.Values) == 0 {
+		return e.Name + ";"
+	}
+
+	return fmt.Sprintf("%s %s;", e.Name, joinNodes(e.Values))
 }
 
-// name '[' size ']' value+ ';'
+// name '[' size? ']' (value (',' value)*)? ';'
+//
+// As with auto vectors, Size is the highest index, so the vector holds
+// Size+1 words.
 type ExternVecInitNode struct {
-	Name   string
-	Size   int
-	Values []Node
+	Span
+	Name      string
+	Size      int
+	SizeExpr  Node // the size as written, nil if built by hand
+	InferSize bool // written as name[], sized to fit the values
+	Values    []Node
 }
 
 func (e ExternVecInitNode) String() string {
-	vals := make([]string, len(e.Values), len(e.Values))
+	size := ""
+	if !e.InferSize {
+		size = sizeString(e.SizeExpr, e.Size)
+	}
+
+	if len(e.Values) == 0 {
+		return fmt.Sprintf("%s [%s];", e.Name, size)
+	}
+
+	return fmt.Sprintf("%s [%s] %s;", e.Name, size, joinNodes(e.Values))
+}
 
-	for i, val := range e.Values {
-		vals[i] = val.String()
+func joinNodes(nodes []Node) string {
+	strs := make([]string, len(nodes), len(nodes))
+
+	for i, node := range nodes {
+		strs[i] = node.String()
 	}
 
-	return fmt.Sprintf("%s [%d] %s;", e.Name, e.Size,
-		strings.Join(vals, ", "))
+	return strings.Join(strs, ", ")
 }
 
 // name '(' (var (',' var)*) ? ')' block
 type FunctionNode struct {
+	Span
 	Name   string
 	Params []string
 	Body   Node
@@ -149,6 +202,7 @@ func (f FunctionNode) String() string {
 }
 
 type FunctionCallNode struct {
+	Span
 	Callable Node
 	Args     []Node
 }
@@ -162,17 +216,31 @@ func (f FunctionCallNode) String() string {
 	return fmt.Sprintf("%s(%s)", f.Callable, strings.Join(args, ", "))
 }
 
-type GotoNode struct{ Label string }
+// Labels are values in B, so a goto can jump through a variable or
+// vector element holding one as well as directly to a label.
+type GotoNode struct {
+	Span
+	Target Node
+}
 
-func (g GotoNode) String() string { return fmt.Sprintf("goto %s;", g.Label) }
+func (g GotoNode) String() string { return fmt.Sprintf("goto %v;", g.Target) }
+
+// The label jumped to, if the target is one directly. Only a name can
+// be a label, but it may equally be a variable.
+func (g GotoNode) Label() (string, bool) {
+	ident, ok := g.Target.(IdentNode)
+	return ident.Value, ok
+}
 
 type IdentNode struct {
+	Span
 	Value string
 }
 
 func (i IdentNode) String() string { return i.Value }
 
 type IfNode struct {
+	Span
 	Cond     Node
 	Body     Node
 	HasElse  bool
@@ -189,81 +257,93 @@ func (i IfNode) String() string {
 	return fmt.Sprintf("if(%v) %v%s", i.Cond, i.Body, elseStr)
 }
 
+// An octal literal's Value is the word's bits, which Target.Eval gives
+// the signed value of.
 type IntegerNode struct {
-	Value int
+	Span
+	Value int64
+	Radix int // 8 or 10, as written in the source
 }
 
-func (i IntegerNode) String() string { return fmt.Sprintf("%d", i.Value) }
+func (i IntegerNode) String() string {
+	if i.Radix == 8 {
+		return "0" + strconv.FormatUint(uint64(i.Value), 8)
+	}
 
-type LabelNode struct{ Name string }
+	return strconv.FormatInt(i.Value, 10)
+}
+
+type LabelNode struct {
+	Span
+	Name string
+}
 
 func (l LabelNode) String() string { return fmt.Sprintf("%s:", l.Name) }
 
-type NullNode struct{}
+type NullNode struct{ Span }
 
 func (n NullNode) String() string { return "" }
 
-type ParenNode struct{ Node Node }
+type ParenNode struct {
+	Span
+	Node Node
+}
 
 func (p ParenNode) String() string { return "(" + p.Node.String() + ")" }
 
-type ReturnNode struct{ Node Node }
+type ReturnNode struct {
+	Span
+	Node Node
+}
 
 func (r ReturnNode) String() string { return fmt.Sprintf("return %v;", r.Node) }
 
 type StatementNode struct {
+	Span
 	Expr Node
 }
 
 func (s StatementNode) String() string { return fmt.Sprintf("%v;", s.Expr) }
 
 type StringNode struct {
+	Span
 	Value string
 }
 
 func (s StringNode) String() string { return fmt.Sprintf("\"%s\"", s.Value) }
 
-type CaseNode struct {
-	Cond       Node
-	Statements []Node
+// 'case' constant ':' or 'default' ':'. Like a goto label, this marks a
+// position rather than containing any statements; it belongs to the
+// innermost switch enclosing it.
+type CaseLabelNode struct {
+	Span
+	Cond    Node  // nil for default
+	Value   int64 // Cond, evaluated
+	Default bool
 }
 
-func (c CaseNode) String() string {
-	str := fmt.Sprintf("\tcase %v:", c.Cond)
-
-	for _, stmt := range c.Statements {
-		str += fmt.Sprintf("\n\t\t%v", stmt)
+func (c CaseLabelNode) String() string {
+	if c.Default {
+		return "default:"
 	}
 
-	return str
+	return fmt.Sprintf("case %v:", c.Cond)
 }
 
 type SwitchNode struct {
-	Cond        Node
-	DefaultCase []Node
-	Cases       []CaseNode
+	Span
+	Cond Node
+	Body Node
 }
 
 func (s SwitchNode) String() string {
-	str := fmt.Sprintf("switch(%v) {", s.Cond)
-
-	for _, cs := range s.Cases {
-		str += "\n" + cs.String()
-	}
-
-	if s.DefaultCase != nil {
-		str += "\ndefault:"
-		for _, stmt := range s.DefaultCase {
-			str += fmt.Sprintf("\n\t%v", stmt)
-		}
-	}
-
-	return str
+	return fmt.Sprintf("switch(%v) %v", s.Cond, s.Body)
 }
 
 // Yes, I know "ternary" is no more descriptive than binary op,
 // but there's only one.
 type TernaryNode struct {
+	Span
 	Cond      Node
 	TrueBody  Node
 	FalseBody Node
@@ -274,6 +354,7 @@ func (t TernaryNode) String() string {
 }
 
 type UnaryNode struct {
+	Span
 	Oper    string
 	Node    Node
 	Postfix bool
@@ -287,12 +368,23 @@ func (u UnaryNode) String() string {
 }
 
 type VarDecl struct {
-	Name    string
-	VecDecl bool
-	Size    int
+	Name     string
+	VecDecl  bool
+	Size     int
+	SizeExpr Node // the size as written, nil if built by hand
+}
+
+// Vector sizes are printed as written where possible.
+func sizeString(expr Node, size int) string {
+	if expr != nil {
+		return expr.String()
+	}
+
+	return strconv.Itoa(size)
 }
 
 type VarDeclNode struct {
+	Span
 	Vars []VarDecl
 }
 
@@ -303,7 +395,8 @@ func (v VarDeclNode) String() string {
 		var str string
 
 		if decl.VecDecl {
-			str = fmt.Sprintf("%s[%d]", decl.Name, decl.Size)
+			str = fmt.Sprintf("%s[%s]", decl.Name,
+				sizeString(decl.SizeExpr, decl.Size))
 		} else {
 			str = decl.Name
 		}
@@ -315,6 +408,7 @@ func (v VarDeclNode) String() string {
 }
 
 type WhileNode struct {
+	Span
 	Cond Node
 	Body Node
 }
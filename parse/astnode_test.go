@@ -12,82 +12,100 @@ var nodefmt = []struct {
 	expr bool
 }{
 	// ArrayAccessNode
-	{ArrayAccessNode{IdentNode{"abc"}, IntegerNode{2}}, "abc[2]", true},
+	{ArrayAccessNode{Array: IdentNode{Value: "abc"},
+		Index: IntegerNode{Value: 2}}, "abc[2]", true},
 
 	// BinaryNode
-	{BinaryNode{IdentNode{"a"}, "==", IdentNode{"b"}}, "a == b", true},
+	{BinaryNode{Left: IdentNode{Value: "a"}, Oper: "==",
+		Right: IdentNode{Value: "b"}}, "a == b", true},
 
 	// IdentNode
-	{IdentNode{"abcd"}, "abcd", true},
+	{IdentNode{Value: "abcd"}, "abcd", true},
 
 	// IfNode
-	{IfNode{Cond: BinaryNode{IdentNode{"a"}, "<", IdentNode{"b"}},
-		Body: StatementNode{FunctionCallNode{IdentNode{"do_this"},
-			[]Node{}}},
+	{IfNode{Cond: BinaryNode{Left: IdentNode{Value: "a"}, Oper: "<",
+		Right: IdentNode{Value: "b"}},
+		Body: StatementNode{Expr: FunctionCallNode{
+			Callable: IdentNode{Value: "do_this"}, Args: []Node{}}},
 		HasElse: false},
 		"if(a < b) do_this();",
 		false},
-	{IfNode{Cond: BinaryNode{IdentNode{"a"}, "<", IdentNode{"b"}},
-		Body: StatementNode{FunctionCallNode{IdentNode{"do_this"},
-			[]Node{}}},
+	{IfNode{Cond: BinaryNode{Left: IdentNode{Value: "a"}, Oper: "<",
+		Right: IdentNode{Value: "b"}},
+		Body: StatementNode{Expr: FunctionCallNode{
+			Callable: IdentNode{Value: "do_this"}, Args: []Node{}}},
 		HasElse: true,
-		ElseBody: StatementNode{FunctionCallNode{IdentNode{"do_that"},
-			[]Node{}}}},
+		ElseBody: StatementNode{Expr: FunctionCallNode{
+			Callable: IdentNode{Value: "do_that"}, Args: []Node{}}}},
 		"if(a < b) do_this(); else do_that();",
 		false},
 
 	// IntegerNode
-	{IntegerNode{1234567890}, "1234567890", true},
+	{IntegerNode{Value: 1234567890}, "1234567890", true},
 
 	// CharacterNode
-	{CharacterNode{""}, "''", true},
-	{CharacterNode{"1"}, "'1'", true},
-	{CharacterNode{"1234"}, "'1234'", true},
+	{CharacterNode{Text: ""}, "''", true},
+	{CharacterNode{Text: "1"}, "'1'", true},
+	{CharacterNode{Text: "1234"}, "'1234'", true},
 
 	// FunctionNode
-	{FunctionNode{"fn", []string{"a", "b", "c"}, BlockNode{}},
+	{FunctionNode{Name: "fn", Params: []string{"a", "b", "c"},
+		Body: BlockNode{}},
 		"fn(a, b, c) {\n}", false},
-	{FunctionNode{"fn", []string{}, BlockNode{}}, "fn() {\n}", false},
+	{FunctionNode{Name: "fn", Params: []string{}, Body: BlockNode{}},
+		"fn() {\n}", false},
 
 	// FunctionCallNode
-	{FunctionCallNode{IdentNode{"fn"}, []Node{IntegerNode{1},
-		CharacterNode{"123"}}},
+	{FunctionCallNode{Callable: IdentNode{Value: "fn"},
+		Args: []Node{IntegerNode{Value: 1}, CharacterNode{Text: "123"}}},
 		"fn(1, '123')", true},
 
 	// BlockNode
-	{BlockNode{[]Node{IntegerNode{1}, IntegerNode{2},
-		IntegerNode{3}}},
+	{BlockNode{Nodes: []Node{IntegerNode{Value: 1}, IntegerNode{Value: 2},
+		IntegerNode{Value: 3}}},
 		"{\n\t1\n\t2\n\t3\n}", false},
 
 	// ExternVarInitNode
-	{ExternVarInitNode{"var", IntegerNode{2}}, "var 2;", false},
+	{ExternVarInitNode{Name: "var", Values: []Node{IntegerNode{Value: 2}}},
+		"var 2;", false},
+	{ExternVarInitNode{Name: "var", Values: []Node{IntegerNode{Value: 2},
+		IdentNode{Value: "main"}}}, "var 2, main;", false},
+	{ExternVarInitNode{Name: "var"}, "var;", false},
 
 	// ExternVecInitNode
-	{ExternVecInitNode{"var", 2, []Node{IntegerNode{2}}}, "var [2] 2;", false},
-	{ExternVecInitNode{"var", 2, []Node{IntegerNode{2}, IntegerNode{3}}},
+	{ExternVecInitNode{Name: "var", Size: 2,
+		Values: []Node{IntegerNode{Value: 2}}}, "var [2] 2;", false},
+	{ExternVecInitNode{Name: "var", Size: 2,
+		Values: []Node{IntegerNode{Value: 2}, IntegerNode{Value: 3}}},
 		"var [2] 2, 3;", false},
+	{ExternVecInitNode{Name: "var", Size: 10}, "var [10];", false},
+	{ExternVecInitNode{Name: "var", InferSize: true,
+		Values: []Node{IdentNode{Value: "a"}}}, "var [] a;", false},
 
 	// ExternVarDeclNode
-	{ExternVarDeclNode{[]string{"a", "b", "c"}}, "extrn a, b, c;", false},
+	{ExternVarDeclNode{Names: []string{"a", "b", "c"}}, "extrn a, b, c;",
+		false},
 
 	// StatementNode
-	{StatementNode{IntegerNode{1}}, "1;", false},
+	{StatementNode{Expr: IntegerNode{Value: 1}}, "1;", false},
 
 	// UnaryNode
-	{UnaryNode{"++", IntegerNode{1}, false}, "++1", true},
-	{UnaryNode{"++", IntegerNode{1}, true}, "1++", true},
+	{UnaryNode{Oper: "++", Node: IntegerNode{Value: 1}}, "++1", true},
+	{UnaryNode{Oper: "++", Node: IntegerNode{Value: 1}, Postfix: true},
+		"1++", true},
 
 	// VarDeclNode
-	{VarDeclNode{[]VarDecl{{"a", false, 0},
-		{"b", true, 12},
-		{"c", false, 0}}},
+	{VarDeclNode{Vars: []VarDecl{{Name: "a"},
+		{Name: "b", VecDecl: true, Size: 12},
+		{Name: "c"}}},
 		"auto a, b[12], c;", false},
 
 	// WhileNode
-	{WhileNode{BinaryNode{IdentNode{"a"}, ">", IdentNode{"b"}},
-		StatementNode{BinaryNode{IdentNode{"a"}, "=",
-			BinaryNode{IdentNode{"a"}, "-",
-				IdentNode{"b"}}}}},
+	{WhileNode{Cond: BinaryNode{Left: IdentNode{Value: "a"}, Oper: ">",
+		Right: IdentNode{Value: "b"}},
+		Body: StatementNode{Expr: BinaryNode{Left: IdentNode{Value: "a"},
+			Oper: "=", Right: BinaryNode{Left: IdentNode{Value: "a"},
+				Oper: "-", Right: IdentNode{Value: "b"}}}}},
 		"while(a > b) a = a - b;", false},
 }
 
@@ -0,0 +1,132 @@
+package parse
+
+import "fmt"
+
+// Evaluate a constant expression, as used for case labels and vector
+// sizes, in the target's word size. Only literals and the operators
+// which don't need storage are allowed: an identifier, string, call or
+// assignment is an error.
+func (t Target) Eval(node Node) (int64, error) {
+	switch node.(type) {
+	case IntegerNode:
+		return t.wrap(node.(IntegerNode).Value), nil
+
+	case CharacterNode:
+		return node.(CharacterNode).Value, nil
+
+	case ParenNode:
+		return t.Eval(node.(ParenNode).Node)
+
+	case UnaryNode:
+		un := node.(UnaryNode)
+
+		val, err := t.Eval(un.Node)
+		if err != nil {
+			return 0, err
+		}
+
+		switch un.Oper {
+		case "-":
+			return t.wrap(-val), nil
+		case "~":
+			return t.wrap(^val), nil
+		case "!":
+			return truth(val == 0), nil
+		}
+
+	case BinaryNode:
+		return t.evalBinary(node.(BinaryNode))
+
+	case TernaryNode:
+		ter := node.(TernaryNode)
+
+		cond, err := t.Eval(ter.Cond)
+		if err != nil {
+			return 0, err
+		}
+
+		if cond != 0 {
+			return t.Eval(ter.TrueBody)
+		}
+		return t.Eval(ter.FalseBody)
+	}
+
+	return 0, NewSemanticError(node, "not a constant expression")
+}
+
+func (t Target) evalBinary(bin BinaryNode) (int64, error) {
+	if IsAssignOp(bin.Oper) {
+		return 0, NewSemanticError(bin, "not a constant expression")
+	}
+
+	left, err := t.Eval(bin.Left)
+	if err != nil {
+		return 0, err
+	}
+
+	right, err := t.Eval(bin.Right)
+	if err != nil {
+		return 0, err
+	}
+
+	switch bin.Oper {
+	case "+":
+		return t.wrap(left + right), nil
+	case "-":
+		return t.wrap(left - right), nil
+	case "*":
+		return t.wrap(left * right), nil
+	case "/", "%":
+		if right == 0 {
+			return 0, NewSemanticError(bin, "division by zero")
+		}
+
+		if bin.Oper == "/" {
+			return t.wrap(left / right), nil
+		}
+		return left % right, nil
+	case "<<", ">>":
+		if right < 0 || right >= int64(t.WordBits()) {
+			return 0, NewSemanticError(bin,
+				fmt.Sprintf("shift count %d out of range", right))
+		}
+
+		if bin.Oper == "<<" {
+			return t.wrap(left << uint(right)), nil
+		}
+		return left >> uint(right), nil
+	case "&":
+		return left & right, nil
+	case "|":
+		return left | right, nil
+	case "^":
+		return left ^ right, nil
+	case "==":
+		return truth(left == right), nil
+	case "!=":
+		return truth(left != right), nil
+	case "<":
+		return truth(left < right), nil
+	case "<=":
+		return truth(left <= right), nil
+	case ">":
+		return truth(left > right), nil
+	case ">=":
+		return truth(left >= right), nil
+	}
+
+	return 0, NewSemanticError(bin, "not a constant expression")
+}
+
+// Truncate to a word, sign extending the result.
+func (t Target) wrap(val int64) int64 {
+	shift := uint(64 - t.WordBits())
+	return val << shift >> shift
+}
+
+func truth(b bool) int64 {
+	if b {
+		return 1
+	}
+	return 0
+}
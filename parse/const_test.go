@@ -0,0 +1,87 @@
+package parse
+
+import (
+	"encoding/binary"
+	"testing"
+)
+
+func TestEval(t *testing.T) {
+	var tests = []struct {
+		src    string
+		expect int64
+	}{
+		{"1", 1},
+		{"017", 15},
+		{"-1", -1},
+		{"'a' + 1", 98},
+		{"2 * (3 + 4)", 14},
+		{"7 / 2", 3},
+		{"-7 % 3", -1},
+		{"1 << 4 | 1", 17},
+		{"~0", -1},
+		{"!5", 0},
+		{"!0", 1},
+		{"3 > 2 == 1", 1},
+		{"6 & 3 ^ 1", 3},
+		{"1 ? 2 : 3", 2},
+		{"0 ? 2 : -3", -3},
+	}
+
+	for _, test := range tests {
+		expr, err := ParseExpr("", test.src)
+		if err != nil {
+			t.Errorf("%s: %v", test.src, err)
+			continue
+		}
+
+		if val, err := DefaultTarget.Eval(expr); err != nil {
+			t.Errorf("%s: %v", test.src, err)
+		} else if val != test.expect {
+			t.Errorf("%s: expected %d, got %d", test.src, test.expect, val)
+		}
+	}
+
+	var bad = []string{
+		"x", "x + 1", "\"str\"", "f()", "v[1]", "*1", "&1", "x = 1",
+		"1 =+ 1", "1 / 0", "1 % (1 - 1)", "1 << 64", "1 >> -1", "1++",
+	}
+
+	for _, src := range bad {
+		expr, err := ParseExpr("", src)
+		if err != nil {
+			t.Errorf("%s: %v", src, err)
+			continue
+		}
+
+		if _, err := DefaultTarget.Eval(expr); err == nil {
+			t.Errorf("%s: expected error", src)
+		} else if _, ok := err.(*SemanticError); !ok {
+			t.Errorf("%s: expected semantic error, got %v", src, err)
+		}
+	}
+}
+
+// Results are truncated to the target's word
+func TestEvalWordSize(t *testing.T) {
+	target := Target{WordSize: 2, ByteOrder: binary.LittleEndian}
+
+	var tests = []struct {
+		src    string
+		expect int64
+	}{
+		{"32767 + 1", -32768},
+		{"1 << 15", -32768},
+		{"256 * 256", 0},
+		{"-32768 - 1", 32767},
+	}
+
+	for _, test := range tests {
+		expr, _ := ParseExpr("", test.src)
+
+		if val, err := target.Eval(expr); err != nil {
+			t.Errorf("%s: %v", test.src, err)
+		} else if val != test.expect {
+			t.Errorf("%s: expected %d, got %d", test.src, test.expect, val)
+		}
+	}
+}
@@ -0,0 +1,218 @@
+package parse
+
+import (
+	"bytes"
+	"fmt"
+	"io/ioutil"
+	"reflect"
+	"sort"
+)
+
+// A concrete syntax tree: the AST with every token of the source, and
+// the whitespace and comments between them, hung off the innermost node
+// containing it. Printing it gives back the source exactly, so tools
+// can rewrite parts of a file and leave the rest untouched.
+type CST struct {
+	Unit TranslationUnit
+	Root *CSTNode // holds the top level declarations and the EOF token
+
+	nodes map[cstKey]*CSTNode
+}
+
+// Nodes are found again by type and extent, as they aren't comparable.
+type cstKey struct {
+	kind       reflect.Type
+	start, end int
+}
+
+func keyOf(node Node) cstKey {
+	return cstKey{reflect.TypeOf(node), node.Pos().Offset, node.End().Offset}
+}
+
+type CSTNode struct {
+	Node  Node // nil for the root
+	Parts []CSTPart
+}
+
+// Either a token belonging directly to a node, or a child node.
+type CSTPart struct {
+	Token *Token
+	Child *CSTNode
+}
+
+// Parse src, which may be a string, []byte or io.Reader, keeping all of
+// it. Syntax errors are returned along with the tree, in which the bad
+// regions are BadNodes.
+func ParseCST(name string, src interface{}) (*CST, error) {
+	input, err := sourceReader(src)
+	if err != nil {
+		return nil, err
+	}
+
+	text, err := ioutil.ReadAll(input)
+	if err != nil {
+		return nil, err
+	}
+
+	// Tokenize's errors are all lexical, the parser reports them too.
+	tokens, _ := Tokenize(name, bytes.NewReader(text))
+	unit, err := NewParser(name, bytes.NewReader(text)).Parse()
+
+	cst := &CST{Unit: unit, nodes: map[cstKey]*CSTNode{}}
+
+	idx := 0
+	cst.Root = &CSTNode{}
+
+	for _, def := range unit.Defs {
+		cst.Root.takeTokens(tokens, &idx, def.Pos().Offset)
+		cst.Root.Parts = append(cst.Root.Parts,
+			CSTPart{Child: cst.build(def, tokens, &idx)})
+	}
+
+	for ; idx < len(tokens); idx++ {
+		cst.Root.Parts = append(cst.Root.Parts, CSTPart{Token: &tokens[idx]})
+	}
+
+	return cst, err
+}
+
+// The CST node for the given AST node, or nil if it isn't part of the
+// tree.
+func (c *CST) Lookup(node Node) *CSTNode {
+	return c.nodes[keyOf(node)]
+}
+
+// Replace the source of an AST node with text. Comments and whitespace
+// leading up to the node are kept.
+func (c *CST) Replace(node Node, text string) error {
+	cst := c.Lookup(node)
+	if cst == nil {
+		return fmt.Errorf("%v: node `%v` not in syntax tree", node.Pos(), node)
+	}
+
+	cst.Replace(text)
+	return nil
+}
+
+func (c *CST) String() string { return c.Root.String() }
+
+func (c *CST) build(node Node, tokens []Token, idx *int) *CSTNode {
+	cst := &CSTNode{Node: node}
+	c.nodes[keyOf(node)] = cst
+
+	for _, child := range childNodes(node) {
+		cst.takeTokens(tokens, idx, child.Pos().Offset)
+		cst.Parts = append(cst.Parts,
+			CSTPart{Child: c.build(child, tokens, idx)})
+	}
+
+	for ; *idx < len(tokens); *idx += 1 {
+		tok := &tokens[*idx]
+
+		if tok.Kind == TokEof || tok.End.Offset > node.End().Offset {
+			break
+		}
+
+		cst.Parts = append(cst.Parts, CSTPart{Token: tok})
+	}
+
+	return cst
+}
+
+// Take the tokens starting before offset.
+func (c *CSTNode) takeTokens(tokens []Token, idx *int, offset int) {
+	for ; *idx < len(tokens); *idx += 1 {
+		tok := &tokens[*idx]
+
+		if tok.Kind == TokEof || tok.Start.Offset >= offset {
+			return
+		}
+
+		c.Parts = append(c.Parts, CSTPart{Token: tok})
+	}
+}
+
+// All tokens of the node and its children, in order.
+func (c *CSTNode) Tokens() []Token {
+	var toks []Token
+
+	for _, part := range c.Parts {
+		if part.Token != nil {
+			toks = append(toks, *part.Token)
+		} else {
+			toks = append(toks, part.Child.Tokens()...)
+		}
+	}
+
+	return toks
+}
+
+// The node's source, exactly as written.
+func (c *CSTNode) String() string { return Untokenize(c.Tokens()) }
+
+// Replace the node's source with text, as a single token which takes
+// over the leading trivia of the first token replaced.
+func (c *CSTNode) Replace(text string) {
+	tok := Token{Kind: TokError, Value: text, Text: text}
+
+	if toks := c.Tokens(); len(toks) > 0 {
+		tok.Start = toks[0].Start
+		tok.End = toks[len(toks)-1].End
+		tok.Trivia = toks[0].Trivia
+	}
+
+	c.Parts = []CSTPart{{Token: &tok}}
+}
+
+var nodeType = reflect.TypeOf((*Node)(nil)).Elem()
+
+// The nodes directly below node, in source order.
+func childNodes(node Node) []Node {
+	var children []Node
+
+	var collect func(reflect.Value)
+	collect = func(val reflect.Value) {
+		switch val.Kind() {
+		case reflect.Interface:
+			if !val.IsNil() {
+				collect(val.Elem())
+			}
+			return
+		case reflect.Slice:
+			for i := 0; i < val.Len(); i++ {
+				collect(val.Index(i))
+			}
+			return
+		}
+
+		if val.Type().Implements(nodeType) {
+			child := val.Interface().(Node)
+			if pos := child.Pos(); pos.IsValid() {
+				children = append(children, child)
+			}
+			return
+		}
+
+		if val.Kind() == reflect.Struct {
+			for i := 0; i < val.NumField(); i++ {
+				if val.Type().Field(i).PkgPath == "" {
+					collect(val.Field(i))
+				}
+			}
+		}
+	}
+
+	// Not collect(node), which would just find node itself
+	val := reflect.ValueOf(node)
+	for i := 0; i < val.NumField(); i++ {
+		if val.Type().Field(i).PkgPath == "" {
+			collect(val.Field(i))
+		}
+	}
+
+	sort.SliceStable(children, func(i, j int) bool {
+		return children[i].Pos().Offset < children[j].Pos().Offset
+	})
+
+	return children
+}
@@ -0,0 +1,122 @@
+package parse
+
+import (
+	"io/ioutil"
+	"strings"
+	"testing"
+)
+
+func TestCSTRoundTrip(t *testing.T) {
+	for _, test := range tests {
+		src, err := ioutil.ReadFile("../examples/" + test)
+		if err != nil {
+			t.Fatalf("failed to open test: %s", err)
+		}
+
+		cst, err := ParseCST(test, src)
+		if err != nil {
+			t.Errorf("%s failed to parse: %v", test, err)
+		}
+
+		if str := cst.String(); str != string(src) {
+			t.Errorf("%s: round trip differs:\n%s", test, str)
+		}
+
+		// Each node holds exactly the tokens it spans
+		var check func(*CSTNode)
+		check = func(node *CSTNode) {
+			toks := node.Tokens()
+			if len(toks) == 0 {
+				return
+			}
+
+			start, end := toks[0].Start.Offset, toks[len(toks)-1].End.Offset
+			if start != node.Node.Pos().Offset || end != node.Node.End().Offset {
+				t.Errorf("%s: %T at %v has tokens %d-%d, expected %d-%d",
+					test, node.Node, node.Node.Pos(), start, end,
+					node.Node.Pos().Offset, node.Node.End().Offset)
+			}
+
+			for _, part := range node.Parts {
+				if part.Child != nil {
+					check(part.Child)
+				}
+			}
+		}
+
+		for _, part := range cst.Root.Parts {
+			if part.Child != nil {
+				check(part.Child)
+			}
+		}
+	}
+}
+
+func TestCSTErrors(t *testing.T) {
+	src := "a 1;\nf() { x = ; y(); }\n/* $ */ b $ 2;\n"
+
+	cst, err := ParseCST("", src)
+	if err == nil {
+		t.Errorf("Expected errors")
+	}
+
+	if str := cst.String(); str != src {
+		t.Errorf("Round trip differs:\n%s", str)
+	}
+}
+
+func TestCSTReplace(t *testing.T) {
+	src := `/* leading */
+a 1;
+
+f(x) {
+	/* keep me */
+	return (x   +   1); /* odd spacing */
+}
+
+b 2;
+`
+
+	cst, err := ParseCST("", src)
+	if err != nil {
+		t.Fatalf("Parse failed: %v", err)
+	}
+
+	// Defs keeps the declarations in order
+	if len(cst.Unit.Defs) != 3 || cst.Unit.Defs[1].(FunctionNode).Name != "f" {
+		t.Fatalf("Expected a, f, b: %v", cst.Unit.Defs)
+	}
+
+	ret := cst.Unit.Funcs[0].Body.(BlockNode).Nodes[0].(ReturnNode)
+	sum := ret.Node.(ParenNode).Node
+
+	node := cst.Lookup(sum)
+	if node == nil {
+		t.Fatalf("Lookup failed")
+	} else if node.String() != "x   +   1" {
+		t.Errorf("Expected node source, got %q", node.String())
+	}
+
+	if err := cst.Replace(sum, "x * 2"); err != nil {
+		t.Fatalf("Replace failed: %v", err)
+	}
+
+	expect := strings.Replace(src, "x   +   1", "x * 2", 1)
+	if str := cst.String(); str != expect {
+		t.Errorf("Expected:\n%s\ngot:\n%s", expect, str)
+	}
+
+	// Comments before the node survive it being replaced
+	if err := cst.Replace(ret, "return (0);"); err != nil {
+		t.Fatalf("Replace failed: %v", err)
+	}
+
+	expect = strings.Replace(src, "return (x   +   1);", "return (0);", 1)
+	if str := cst.String(); str != expect {
+		t.Errorf("Expected:\n%s\ngot:\n%s", expect, str)
+	}
+
+	if err := cst.Replace(IdentNode{Value: "z"}, "y"); err == nil {
+		t.Errorf("Expected error replacing node not in the tree")
+	}
+}
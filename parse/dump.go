@@ -0,0 +1,187 @@
+package parse
+
+import (
+	"fmt"
+	"io"
+	"reflect"
+	"strconv"
+	"strings"
+	"text/scanner"
+)
+
+// Print node as an indented tree showing the type, position and every
+// field of each node, one per line:
+//
+//	BinaryNode 1:1-1:6
+//	  Left: IdentNode 1:1-1:2
+//	    Value: "a"
+//	  Oper: "+"
+//	  ...
+func FprintTree(w io.Writer, node Node) error {
+	var buf strings.Builder
+
+	printTree(&buf, reflect.ValueOf(&node).Elem(), "")
+	buf.WriteString("\n")
+
+	_, err := io.WriteString(w, buf.String())
+	return err
+}
+
+// Print node as an S-expression, with a node's fields given as keywords.
+// Fields from the first one holding a node or list on start a new line:
+//
+//	(BinaryNode 1:1-1:6
+//	  :left (IdentNode 1:1-1:2 :value "a")
+//	  :oper "+"
+//	  ...)
+func FprintSexp(w io.Writer, node Node) error {
+	var buf strings.Builder
+
+	printSexp(&buf, reflect.ValueOf(&node).Elem(), "")
+	buf.WriteString("\n")
+
+	_, err := io.WriteString(w, buf.String())
+	return err
+}
+
+func spanString(start, end scanner.Position) string {
+	if !start.IsValid() {
+		return "-"
+	}
+
+	return fmt.Sprintf("%d:%d-%d:%d", start.Line, start.Column, end.Line,
+		end.Column)
+}
+
+// Values printed as is, on the same line as their field name.
+func isPlain(val reflect.Value) bool {
+	switch val.Kind() {
+	case reflect.Interface:
+		return val.IsNil()
+	case reflect.Slice:
+		return val.Len() == 0 || val.Type().Elem().Kind() == reflect.String
+	case reflect.Struct:
+		return false
+	}
+
+	return true
+}
+
+func plainString(val reflect.Value) string {
+	switch val.Kind() {
+	case reflect.Interface:
+		return "nil"
+	case reflect.String:
+		return strconv.Quote(val.String())
+	case reflect.Slice:
+		elems := make([]string, val.Len())
+		for i := range elems {
+			elems[i] = plainString(val.Index(i))
+		}
+		return "[" + strings.Join(elems, ", ") + "]"
+	}
+
+	return fmt.Sprint(val.Interface())
+}
+
+// The fields of a node or other struct to print, leaving out the span.
+func dumpFields(val reflect.Value) []reflect.StructField {
+	var fields []reflect.StructField
+
+	for i := 0; i < val.NumField(); i++ {
+		field := val.Type().Field(i)
+
+		if field.PkgPath == "" && field.Type != reflect.TypeOf(Span{}) {
+			fields = append(fields, field)
+		}
+	}
+
+	return fields
+}
+
+func printTree(buf *strings.Builder, val reflect.Value, indent string) {
+	if isPlain(val) {
+		buf.WriteString(plainString(val))
+		return
+	}
+
+	switch val.Kind() {
+	case reflect.Interface:
+		node := val.Interface().(Node)
+		fmt.Fprintf(buf, "%s %s", val.Elem().Type().Name(),
+			spanString(node.Pos(), node.End()))
+		val = val.Elem()
+
+	case reflect.Slice:
+		fmt.Fprintf(buf, "[%d]", val.Len())
+
+		for i := 0; i < val.Len(); i++ {
+			fmt.Fprintf(buf, "\n%s  %d: ", indent, i)
+			printTree(buf, val.Index(i), indent+"  ")
+		}
+		return
+
+	case reflect.Struct:
+		buf.WriteString(val.Type().Name())
+	}
+
+	for _, field := range dumpFields(val) {
+		fmt.Fprintf(buf, "\n%s  %s: ", indent, field.Name)
+		printTree(buf, val.FieldByIndex(field.Index), indent+"  ")
+	}
+}
+
+func printSexp(buf *strings.Builder, val reflect.Value, indent string) {
+	if isPlain(val) {
+		if val.Kind() == reflect.Slice {
+			// Lists are written with parens, as is everything else
+			str := plainString(val)
+			str = strings.Replace(str[1:len(str)-1], ", ", " ", -1)
+			buf.WriteString("(" + str + ")")
+		} else {
+			buf.WriteString(plainString(val))
+		}
+		return
+	}
+
+	switch val.Kind() {
+	case reflect.Interface:
+		node := val.Interface().(Node)
+		fmt.Fprintf(buf, "(%s %s", val.Elem().Type().Name(),
+			spanString(node.Pos(), node.End()))
+		val = val.Elem()
+
+	case reflect.Slice:
+		buf.WriteString("(")
+
+		for i := 0; i < val.Len(); i++ {
+			if i > 0 {
+				buf.WriteString("\n" + indent + " ")
+			}
+			printSexp(buf, val.Index(i), indent+" ")
+		}
+
+		buf.WriteString(")")
+		return
+
+	case reflect.Struct:
+		buf.WriteString("(" + val.Type().Name())
+	}
+
+	// Once a field has taken a line of its own, so do the rest
+	broken := false
+
+	for _, field := range dumpFields(val) {
+		fieldVal := val.FieldByIndex(field.Index)
+
+		if broken = broken || !isPlain(fieldVal); broken {
+			buf.WriteString("\n" + indent + "  :" + jsonName(field.Name) + " ")
+		} else {
+			buf.WriteString(" :" + jsonName(field.Name) + " ")
+		}
+
+		printSexp(buf, fieldVal, indent+"  ")
+	}
+
+	buf.WriteString(")")
+}
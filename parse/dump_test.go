@@ -0,0 +1,80 @@
+package parse
+
+import (
+	"strings"
+	"testing"
+)
+
+func TestFprintTree(t *testing.T) {
+	expr, err := ParseExpr("x.b", "a = b ? 1 : f(c)[2]")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	var buf strings.Builder
+	if err := FprintTree(&buf, expr); err != nil {
+		t.Fatal(err)
+	}
+
+	expected := `BinaryNode 1:1-1:20
+  Left: IdentNode 1:1-1:2
+    Value: "a"
+  Oper: "="
+  Right: TernaryNode 1:5-1:20
+    Cond: IdentNode 1:5-1:6
+      Value: "b"
+    TrueBody: IntegerNode 1:9-1:10
+      Value: 1
+      Radix: 10
+    FalseBody: ArrayAccessNode 1:13-1:20
+      Array: FunctionCallNode 1:13-1:17
+        Callable: IdentNode 1:13-1:14
+          Value: "f"
+        Args: [1]
+          0: IdentNode 1:15-1:16
+            Value: "c"
+      Index: IntegerNode 1:18-1:19
+        Value: 2
+        Radix: 10
+`
+
+	if buf.String() != expected {
+		t.Errorf("Expected:\n%s\ngot:\n%s", expected, buf.String())
+	}
+}
+
+func TestFprintSexp(t *testing.T) {
+	fn, err := ParseTopLevel("x.b", "f(a, b) { auto v[2]; return (-a); }")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	var buf strings.Builder
+	if err := FprintSexp(&buf, fn); err != nil {
+		t.Fatal(err)
+	}
+
+	expected := `(FunctionNode 1:1-1:36 :name "f" :params ("a" "b")
+  :body (BlockNode 1:9-1:36
+    :nodes ((VarDeclNode 1:11-1:21
+       :vars ((VarDecl :name "v" :vecDecl true :size 2
+          :sizeExpr (IntegerNode 1:18-1:19 :value 2 :radix 10))))
+     (ReturnNode 1:22-1:34
+       :node (ParenNode 1:29-1:33
+         :node (UnaryNode 1:30-1:32 :oper "-"
+           :node (IdentNode 1:31-1:32 :value "a")
+           :postfix false))))))
+`
+
+	if buf.String() != expected {
+		t.Errorf("Expected:\n%s\ngot:\n%s", expected, buf.String())
+	}
+
+	// Nodes built by hand have no position
+	buf.Reset()
+	FprintSexp(&buf, BreakNode{})
+
+	if buf.String() != "(BreakNode -)\n" {
+		t.Errorf("Expected (BreakNode -), got %s", buf.String())
+	}
+}
@@ -0,0 +1,53 @@
+package parse
+
+import "reflect"
+
+// Report whether two ASTs are the same, ignoring where their nodes came
+// from. A nil list and an empty one are equal, as the parser makes
+// either depending on the construct.
+func Equal(a, b Node) bool {
+	return equalValues(reflect.ValueOf(&a).Elem(), reflect.ValueOf(&b).Elem())
+}
+
+func equalValues(a, b reflect.Value) bool {
+	switch a.Kind() {
+	case reflect.Interface:
+		if a.IsNil() || b.IsNil() {
+			return a.IsNil() == b.IsNil()
+		}
+
+		if a.Elem().Type() != b.Elem().Type() {
+			return false
+		}
+
+		return equalValues(a.Elem(), b.Elem())
+
+	case reflect.Slice:
+		if a.Len() != b.Len() {
+			return false
+		}
+
+		for i := 0; i < a.Len(); i++ {
+			if !equalValues(a.Index(i), b.Index(i)) {
+				return false
+			}
+		}
+
+		return true
+
+	case reflect.Struct:
+		for i := 0; i < a.NumField(); i++ {
+			if a.Type().Field(i).Type == reflect.TypeOf(Span{}) {
+				continue
+			}
+
+			if !equalValues(a.Field(i), b.Field(i)) {
+				return false
+			}
+		}
+
+		return true
+	}
+
+	return a.Interface() == b.Interface()
+}
@@ -0,0 +1,35 @@
+package parse
+
+import "testing"
+
+func TestEqual(t *testing.T) {
+	parse := func(src string) Node {
+		node, err := ParseStmt("x.b", src)
+		if err != nil {
+			t.Fatalf("%s: %v", src, err)
+		}
+		return node
+	}
+
+	var tests = []struct {
+		a, b  string
+		equal bool
+	}{
+		{"x = a+b;", "x =  a + b ;", true},
+		{"{ f(); }", "{\n\tf( );\n}", true},
+		{"x = a+b;", "x = (a+b);", false},
+		{"x = 010;", "x = 8;", false},
+		{"return;", "return ;", true},
+		{"if (a) b; else c;", "if (a) b; else d;", false},
+	}
+
+	for _, test := range tests {
+		if Equal(parse(test.a), parse(test.b)) != test.equal {
+			t.Errorf("Equal(%q, %q) != %v", test.a, test.b, test.equal)
+		}
+	}
+
+	if !Equal(nil, nil) || Equal(nil, NullNode{}) {
+		t.Errorf("Equal mishandles nil")
+	}
+}
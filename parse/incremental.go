@@ -0,0 +1,426 @@
+package parse
+
+import (
+	"bytes"
+	"fmt"
+	"reflect"
+	"sort"
+	"text/scanner"
+	"unicode/utf8"
+)
+
+// A Document is a source file kept parsed as it is edited, for editor
+// integration. Top level declarations can't affect how the ones around
+// them parse, so an edit only re-lexes and re-parses the declarations
+// it touches, along with the one after them to check that the edit
+// hasn't moved where one declaration ends and the next begins. Syntax
+// errors elsewhere in the file make no difference.
+//
+// The declarations after an edit are just moved along: how far is noted
+// against each, and their positions are only brought up to date when
+// they're next asked for. Any edit before a declaration can change its
+// line and column, even one which leaves it at the same offset.
+type Document struct {
+	Target Target
+
+	name  string
+	src   []byte
+	lines []int  // offset of the start of each line
+	defs  []Node // top level declarations, in source order
+	moved []int  // bytes each of defs has moved since it was positioned
+	stale []bool // whether each of defs has been edited around since
+	errs  ErrorList
+}
+
+func NewDocument(name string, src []byte) *Document {
+	doc := &Document{
+		Target: DefaultTarget,
+		name:   name,
+		src:    append([]byte{}, src...),
+	}
+
+	doc.Reparse()
+	return doc
+}
+
+func (d *Document) Source() []byte { return d.src }
+
+// The top level declarations, including a BadNode for each that failed
+// to parse.
+func (d *Document) Definitions() []Node {
+	d.settle()
+	return d.defs
+}
+
+// Syntax errors, as returned by Parser.Parse.
+func (d *Document) Err() error {
+	if len(d.errs) == 0 {
+		return nil
+	}
+
+	return d.errs
+}
+
+func (d *Document) Unit() TranslationUnit {
+	unit := TranslationUnit{File: d.name}
+
+	for _, def := range d.Definitions() {
+		switch def.(type) {
+		case FunctionNode:
+			unit.Funcs = append(unit.Funcs, def.(FunctionNode))
+		case BadNode:
+			unit.Bad = append(unit.Bad, def.(BadNode))
+		default:
+			unit.Vars = append(unit.Vars, def)
+		}
+
+		unit.Defs = append(unit.Defs, def)
+	}
+
+	return unit
+}
+
+// Parse the whole document from scratch.
+func (d *Document) Reparse() {
+	d.indexLines()
+
+	defs, errs := d.parseRegion(0, len(d.src))
+	d.defs, d.errs = defs, errs
+	d.moved, d.stale = make([]int, len(defs)), make([]bool, len(defs))
+}
+
+// Replace length bytes at offset with text, returning the declarations
+// which were parsed again as a result.
+func (d *Document) Edit(offset, length int, text string) ([]Node, error) {
+	if offset < 0 || length < 0 || offset+length > len(d.src) {
+		return nil, fmt.Errorf("edit %d+%d out of range of %d bytes",
+			offset, length, len(d.src))
+	}
+
+	// Declarations touching the edit, first through last-1. Touching
+	// counts, as text added right before or after a declaration may
+	// well be part of it.
+	first := sort.Search(len(d.defs), func(i int) bool {
+		return d.defEnd(i) >= offset
+	})
+	last := sort.Search(len(d.defs), func(i int) bool {
+		return d.defStart(i) > offset+length
+	})
+
+	// A broken declaration may have run into the end of the file, and
+	// one ending in an if would take an else added after it.
+	if first > 0 {
+		if _, bad := d.defs[first-1].(BadNode); bad ||
+			danglingIf(d.defs[first-1]) {
+			first--
+		}
+	}
+
+	// Reparse everything between the untouched declarations either side.
+	start, end := 0, len(d.src)
+	if first > 0 {
+		start = d.defEnd(first - 1)
+	}
+	if last < len(d.defs) {
+		end = d.defStart(last)
+	}
+
+	delta := len(text) - length
+	d.splice(offset, length, text)
+
+	for i := last; i < len(d.defs); i++ {
+		d.moved[i] += delta
+		d.stale[i] = true
+	}
+
+	defs, regionErrs, ok := d.parseBefore(start, last)
+	if !ok {
+		last = len(d.defs)
+		defs, regionErrs = d.parseRegion(start, len(d.src))
+	}
+
+	// Errors before the edit stay as they are, and those in the
+	// declarations after it move along with them.
+	var errs ErrorList
+
+	for _, err := range d.errs {
+		switch offset := errorPos(err).Offset; {
+		case offset < start:
+			errs = append(errs, err)
+		case offset >= end && last < len(d.defs):
+			errs = append(errs, mapErrorPositions(err, d.mover(delta)))
+		}
+	}
+
+	errs = append(errs, regionErrs...)
+	sort.Stable(errs)
+
+	d.defs = append(append(d.defs[:first:first], defs...), d.defs[last:]...)
+	d.moved = append(append(d.moved[:first:first], make([]int, len(defs))...),
+		d.moved[last:]...)
+	d.stale = append(append(d.stale[:first:first],
+		make([]bool, len(defs))...), d.stale[last:]...)
+	d.errs = errs
+
+	return defs, nil
+}
+
+// Parse from start up to declaration next, which is parsed again too:
+// if it comes out where it already was, the declarations before it are
+// the ones the whole file would give. Reports false if it doesn't.
+func (d *Document) parseBefore(start, next int) ([]Node, ErrorList, bool) {
+	if next == len(d.defs) {
+		defs, errs := d.parseRegion(start, len(d.src))
+		return defs, errs, true
+	}
+
+	end := d.defStart(next)
+
+	defs, errs := d.parseRegion(start, d.defEnd(next))
+	if len(defs) == 0 {
+		return nil, nil, false
+	}
+
+	sentinel := defs[len(defs)-1]
+	if sentinel.Pos().Offset != end ||
+		sentinel.End().Offset != d.defEnd(next) {
+		return nil, nil, false
+	}
+
+	// Its errors are already known
+	var before ErrorList
+	for _, err := range errs {
+		if errorPos(err).Offset < end {
+			before = append(before, err)
+		}
+	}
+
+	return defs[:len(defs)-1], before, true
+}
+
+// Parse the source from start to end, which must lie between top level
+// declarations.
+func (d *Document) parseRegion(start, end int) ([]Node, ErrorList) {
+	p := NewParser(d.name, bytes.NewReader(d.src[start:end]))
+	p.Target = d.Target
+
+	defs := p.parseDefinitions()
+
+	var errs ErrorList
+	if err := p.errorList(nil); err != nil {
+		errs = err.(ErrorList)
+	}
+
+	if start == 0 {
+		return defs, errs
+	}
+
+	// Positions are relative to the region
+	fromRegion := func(pos scanner.Position) scanner.Position {
+		return d.position(start + pos.Offset)
+	}
+
+	for i, def := range defs {
+		defs[i] = mapPositions(def, fromRegion)
+	}
+
+	for i, err := range errs {
+		errs[i] = mapErrorPositions(err, fromRegion)
+	}
+
+	return defs, errs
+}
+
+// Where declaration i starts in the source as it is now.
+func (d *Document) defStart(i int) int {
+	return d.defs[i].Pos().Offset + d.moved[i]
+}
+
+// Where declaration i ends in the source as it is now.
+func (d *Document) defEnd(i int) int {
+	return d.defs[i].End().Offset + d.moved[i]
+}
+
+// Bring the positions of the declarations which have moved up to date.
+func (d *Document) settle() {
+	for i, stale := range d.stale {
+		if stale {
+			d.defs[i] = mapPositions(d.defs[i], d.mover(d.moved[i]))
+			d.moved[i], d.stale[i] = 0, false
+		}
+	}
+}
+
+func (d *Document) mover(delta int) func(scanner.Position) scanner.Position {
+	return func(pos scanner.Position) scanner.Position {
+		return d.position(pos.Offset + delta)
+	}
+}
+
+// Whether an else following node would belong to it.
+func danglingIf(node Node) bool {
+	switch n := node.(type) {
+	case FunctionNode:
+		return danglingIf(n.Body)
+	case IfNode:
+		return !n.HasElse || danglingIf(n.ElseBody)
+	case SwitchNode:
+		return danglingIf(n.Body)
+	case WhileNode:
+		return danglingIf(n.Body)
+	}
+
+	return false
+}
+
+// Replace length bytes at offset with text, keeping the line index up
+// to date: lines starting before the edit stay where they are, and
+// those after it move along with the text.
+func (d *Document) splice(offset, length int, text string) {
+	delta := len(text) - length
+
+	src := make([]byte, 0, len(d.src)+delta)
+	src = append(src, d.src[:offset]...)
+	src = append(src, text...)
+	src = append(src, d.src[offset+length:]...)
+	d.src = src
+
+	before := sort.Search(len(d.lines), func(i int) bool {
+		return d.lines[i] > offset
+	})
+	after := sort.Search(len(d.lines), func(i int) bool {
+		return d.lines[i] > offset+length
+	})
+
+	lines := append([]int{}, d.lines[:before]...)
+
+	for i := 0; i < len(text); i++ {
+		if text[i] == '\n' {
+			lines = append(lines, offset+i+1)
+		}
+	}
+
+	for _, line := range d.lines[after:] {
+		lines = append(lines, line+delta)
+	}
+
+	d.lines = lines
+}
+
+// Record where each line starts, for position.
+func (d *Document) indexLines() {
+	d.lines = append(d.lines[:0], 0)
+
+	for i, c := range d.src {
+		if c == '\n' {
+			d.lines = append(d.lines, i+1)
+		}
+	}
+}
+
+// The position of a byte offset into the source, as the lexer would
+// have reported it.
+func (d *Document) position(offset int) scanner.Position {
+	line := sort.Search(len(d.lines), func(i int) bool {
+		return d.lines[i] > offset
+	})
+	lineStart := d.lines[line-1]
+
+	return scanner.Position{
+		Filename: d.name,
+		Offset:   offset,
+		Line:     line,
+		Column:   utf8.RuneCount(d.src[lineStart:offset]) + 1,
+	}
+}
+
+// A copy of err with its positions replaced by f(pos).
+func mapErrorPositions(err error,
+	f func(scanner.Position) scanner.Position) error {
+
+	move := func(pos scanner.Position) scanner.Position {
+		if pos.IsValid() {
+			return f(pos)
+		}
+		return pos
+	}
+
+	switch e := err.(type) {
+	case *LexError:
+		return &LexError{move(e.pos), e.msg}
+
+	case *ParseError:
+		tok := e.tok
+		tok.Start, tok.End = move(tok.Start), move(tok.End)
+		tok.Trivia = append([]Trivia{}, tok.Trivia...)
+
+		for i := range tok.Trivia {
+			tok.Trivia[i].Start = move(tok.Trivia[i].Start)
+			tok.Trivia[i].End = move(tok.Trivia[i].End)
+		}
+
+		return &ParseError{tok, e.msg}
+
+	case *SemanticError:
+		return &SemanticError{mapPositions(e.node, f), e.msg}
+	}
+
+	return err
+}
+
+var positionType = reflect.TypeOf(scanner.Position{})
+
+// A copy of node with every valid position in it replaced by f(pos).
+func mapPositions(node Node, f func(scanner.Position) scanner.Position) Node {
+	val := reflect.New(reflect.TypeOf(node)).Elem()
+	val.Set(reflect.ValueOf(node))
+
+	mapValuePositions(val, f)
+
+	return val.Interface().(Node)
+}
+
+// val must be settable. Slices and nodes behind interfaces are copied
+// rather than changed in place, as they may be shared with the original.
+func mapValuePositions(val reflect.Value,
+	f func(scanner.Position) scanner.Position) {
+
+	switch val.Kind() {
+	case reflect.Struct:
+		if val.Type() == positionType {
+			if pos := val.Interface().(scanner.Position); pos.IsValid() {
+				val.Set(reflect.ValueOf(f(pos)))
+			}
+			return
+		}
+
+		for i := 0; i < val.NumField(); i++ {
+			if val.Type().Field(i).PkgPath == "" {
+				mapValuePositions(val.Field(i), f)
+			}
+		}
+
+	case reflect.Interface:
+		if val.IsNil() {
+			return
+		}
+
+		elem := reflect.New(val.Elem().Type()).Elem()
+		elem.Set(val.Elem())
+		mapValuePositions(elem, f)
+		val.Set(elem)
+
+	case reflect.Slice:
+		if val.IsNil() {
+			return
+		}
+
+		elems := reflect.MakeSlice(val.Type(), val.Len(), val.Len())
+		reflect.Copy(elems, val)
+
+		for i := 0; i < elems.Len(); i++ {
+			mapValuePositions(elems.Index(i), f)
+		}
+		val.Set(elems)
+	}
+}
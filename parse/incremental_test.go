@@ -0,0 +1,202 @@
+package parse
+
+import (
+	"fmt"
+	"reflect"
+	"strings"
+	"testing"
+)
+
+const docSource = `/* globals */
+a 1;
+v[2] 1, 2, 3;
+
+f(x) {
+	auto y;
+	y = x + 1;
+	return (y);
+}
+
+g() { return (a); } h() { return (f(v[0])); }
+`
+
+// After each edit, the document must be just as if it was parsed from
+// scratch, positions and all.
+func TestDocumentEdit(t *testing.T) {
+	var edits = []struct {
+		old, new string
+		reparsed int // top level declarations parsed again
+	}{
+		{"y = x + 1;", "y = x * 2 + 1;", 1},
+		{"auto y;", "auto y, z;\n\tz = 2;", 1},
+		{"return (a); }", "return (a + 1);\n}", 1},
+		{"a 1;", "a 10;\nb 2;", 2},
+		{"/* globals */", "/* globals\n * and vectors */", 0},
+		{"\ng() {", "\nk() {}\ng() {", 2},
+		{"h() { return (f(v[0])); }\n", "", 0},
+		{"v[2] 1, 2, 3;", "v[] 1, 2, 3;", 1},
+		// Same length, one line fewer
+		{"{\n\tauto y, z;", "{ \tauto y, z;", 1},
+	}
+
+	src := docSource
+	doc := NewDocument("doc.b", []byte(src))
+
+	if doc.Err() != nil {
+		t.Fatalf("Parse failed: %v", doc.Err())
+	}
+
+	for _, edit := range edits {
+		offset := strings.Index(src, edit.old)
+		src = src[:offset] + edit.new + src[offset+len(edit.old):]
+
+		reparsed, err := doc.Edit(offset, len(edit.old), edit.new)
+		if err != nil {
+			t.Fatalf("%q: %v", edit.old, err)
+		}
+
+		if string(doc.Source()) != src {
+			t.Fatalf("%q: source not updated:\n%s", edit.old, doc.Source())
+		}
+
+		if len(reparsed) != edit.reparsed {
+			t.Errorf("%q: expected %d declarations reparsed, got %v",
+				edit.old, edit.reparsed, reparsed)
+		}
+
+		fresh := NewDocument("doc.b", []byte(src))
+		if !reflect.DeepEqual(doc.Definitions(), fresh.Definitions()) {
+			t.Errorf("%q: incremental parse differs:\n%v\nfrom:\n%v",
+				edit.old, doc.Definitions(), fresh.Definitions())
+		}
+	}
+}
+
+func TestDocumentEditErrors(t *testing.T) {
+	src := docSource
+	doc := NewDocument("doc.b", []byte(src))
+
+	// Removing a closing brace runs f on into g, so it can't be
+	// reparsed on its own.
+	offset := strings.Index(src, "}")
+	doc.Edit(offset, 1, "")
+	src = src[:offset] + src[offset+1:]
+
+	if doc.Err() == nil {
+		t.Fatalf("Expected syntax error")
+	}
+
+	fresh := NewDocument("doc.b", []byte(src))
+	if !reflect.DeepEqual(doc.Definitions(), fresh.Definitions()) ||
+		doc.Err().Error() != fresh.Err().Error() {
+		t.Errorf("Expected full reparse")
+	}
+
+	// And putting it back fixes everything
+	doc.Edit(offset, 0, "}")
+
+	if doc.Err() != nil {
+		t.Errorf("Unexpected error: %v", doc.Err())
+	}
+
+	if len(doc.Unit().Funcs) != 3 || len(doc.Unit().Vars) != 2 {
+		t.Errorf("Expected 3 functions and 2 variables: %v", doc.Unit())
+	}
+
+	// An unterminated comment swallows the rest of the file
+	offset = strings.Index(docSource, "f(x)")
+	doc.Edit(offset, 0, "/*")
+
+	if doc.Err() == nil || len(doc.Definitions()) != 2 {
+		t.Errorf("Expected error and 2 definitions, got %v: %v",
+			doc.Err(), doc.Definitions())
+	}
+
+	if _, err := doc.Edit(len(doc.Source()), 1, ""); err == nil {
+		t.Errorf("Expected out of range error")
+	}
+}
+
+// Apply an edit replacing old in src, checking the document comes out
+// as if parsed from scratch, errors and all. Returns the declarations
+// reparsed.
+func editDocument(t *testing.T, doc *Document, src *string,
+	old, new string) []Node {
+
+	offset := strings.Index(*src, old)
+	*src = (*src)[:offset] + new + (*src)[offset+len(old):]
+
+	reparsed, err := doc.Edit(offset, len(old), new)
+	if err != nil {
+		t.Fatalf("%q: %v", old, err)
+	}
+
+	fresh := NewDocument("doc.b", []byte(*src))
+
+	if !reflect.DeepEqual(doc.Definitions(), fresh.Definitions()) {
+		t.Errorf("%q: incremental parse differs:\n%v\nfrom:\n%v",
+			old, doc.Definitions(), fresh.Definitions())
+	}
+
+	if fmt.Sprint(doc.Err()) != fmt.Sprint(fresh.Err()) {
+		t.Errorf("%q: errors differ:\n%v\nfrom:\n%v", old, doc.Err(),
+			fresh.Err())
+	}
+
+	return reparsed
+}
+
+// A syntax error elsewhere in the file, as there usually is while
+// typing, doesn't stop edits being parsed on their own.
+func TestDocumentEditWithErrors(t *testing.T) {
+	src := strings.Replace(docSource, "return (a);", "return (a +);", 1)
+	doc := NewDocument("doc.b", []byte(src))
+
+	if doc.Err() == nil {
+		t.Fatalf("Expected syntax error")
+	}
+
+	var edits = []struct {
+		old, new string
+		reparsed int
+	}{
+		{"y = x + 1;", "y = x * 2 + 1;", 1},
+		{"a 1;", "a 10;", 1},
+		{"(a +)", "(a + )", 1},
+		{"return (f(v[0]));", "return (f(v[0]) +);", 1},
+		{"(a + )", "(a + 1)", 1},
+		{"y = x * 2", "y = x *", 1},
+	}
+
+	for _, edit := range edits {
+		reparsed := editDocument(t, doc, &src, edit.old, edit.new)
+
+		if len(reparsed) != edit.reparsed {
+			t.Errorf("%q: expected %d declarations reparsed, got %v",
+				edit.old, edit.reparsed, reparsed)
+		}
+	}
+}
+
+// Edits which change where declarations divide
+func TestDocumentEditBoundaries(t *testing.T) {
+	src := "f(x) if (x) x = 1;\ng() {}\nh() {\n"
+	doc := NewDocument("doc.b", []byte(src))
+
+	// An else after an if belongs to it
+	editDocument(t, doc, &src, "g()", "else x = 2;\ng()")
+
+	// A declaration running into the end of the file takes in what's
+	// added after it
+	editDocument(t, doc, &src, "h() {\n", "h() {\n\treturn;\n")
+	editDocument(t, doc, &src, "return;\n", "return;\n}\n")
+
+	if doc.Err() != nil || len(doc.Definitions()) != 3 {
+		t.Errorf("Expected 3 definitions, got %v: %v", doc.Definitions(),
+			doc.Err())
+	}
+
+	// Opening a block swallows the following declarations
+	editDocument(t, doc, &src, "g() {}", "g() { {")
+	editDocument(t, doc, &src, "g() { {", "g() { {}")
+}
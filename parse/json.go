@@ -0,0 +1,339 @@
+package parse
+
+import (
+	"encoding/json"
+	"fmt"
+	"reflect"
+	"text/scanner"
+	"unicode"
+	"unicode/utf8"
+)
+
+// The version of the JSON encoding written by Marshal. It changes
+// whenever a node type or field is added, removed or renamed, and
+// Unmarshal only reads its own version.
+const JSONVersion = 1
+
+// A unit is encoded as
+//
+//	{"version": 1, "file": "name.b", "defs": [node, ...]}
+//
+// and each node as an object tagged with its type, holding its position
+// and its fields, named as in Go with a lower case first letter:
+//
+//	{"kind": "BinaryNode",
+//	 "pos": {"offset": 4, "line": 1, "column": 5}, "end": {...},
+//	 "left": node, "oper": "+", "right": node}
+//
+// Positions outside of any source, as on nodes built by hand, are null.
+// Their file name is that of the unit.
+type jsonUnit struct {
+	Version int               `json:"version"`
+	File    string            `json:"file"`
+	Defs    []json.RawMessage `json:"defs"`
+}
+
+type jsonPosition struct {
+	Offset int `json:"offset"`
+	Line   int `json:"line"`
+	Column int `json:"column"`
+}
+
+var nodeTypes = map[string]reflect.Type{}
+
+func init() {
+	for _, node := range []Node{
+		ArrayAccessNode{}, BadNode{}, BinaryNode{}, BlockNode{},
+		BreakNode{}, CaseLabelNode{}, CharacterNode{}, ExternVarDeclNode{},
+		ExternVarInitNode{}, ExternVecInitNode{}, FunctionNode{},
+		FunctionCallNode{}, GotoNode{}, IdentNode{}, IfNode{}, IntegerNode{},
+		LabelNode{}, NullNode{}, ParenNode{}, ReturnNode{}, StatementNode{},
+		StringNode{}, SwitchNode{}, TernaryNode{}, UnaryNode{},
+		VarDeclNode{}, WhileNode{},
+	} {
+		nodeTypes[reflect.TypeOf(node).Name()] = reflect.TypeOf(node)
+	}
+}
+
+func Marshal(unit TranslationUnit) ([]byte, error) {
+	out := jsonUnit{Version: JSONVersion, File: unit.File}
+
+	for _, def := range unit.Definitions() {
+		data, err := MarshalNode(def)
+		if err != nil {
+			return nil, err
+		}
+
+		out.Defs = append(out.Defs, data)
+	}
+
+	return json.Marshal(out)
+}
+
+func Unmarshal(data []byte) (TranslationUnit, error) {
+	var in jsonUnit
+
+	if err := json.Unmarshal(data, &in); err != nil {
+		return TranslationUnit{}, err
+	}
+
+	if in.Version != JSONVersion {
+		return TranslationUnit{}, fmt.Errorf(
+			"unsupported AST version %d, expected %d", in.Version,
+			JSONVersion)
+	}
+
+	unit := TranslationUnit{File: in.File}
+
+	for _, raw := range in.Defs {
+		def, err := unmarshalNode(raw, in.File)
+		if err != nil {
+			return TranslationUnit{}, err
+		}
+
+		switch def.(type) {
+		case FunctionNode:
+			unit.Funcs = append(unit.Funcs, def.(FunctionNode))
+		case BadNode:
+			unit.Bad = append(unit.Bad, def.(BadNode))
+		case ExternVarInitNode, ExternVecInitNode:
+			unit.Vars = append(unit.Vars, def)
+		default:
+			return TranslationUnit{}, fmt.Errorf(
+				"%s is not a top level declaration",
+				reflect.TypeOf(def).Name())
+		}
+
+		unit.Defs = append(unit.Defs, def)
+	}
+
+	return unit, nil
+}
+
+// Encode a single node, as it would appear in a unit.
+func MarshalNode(node Node) (json.RawMessage, error) {
+	val, err := encodeValue(reflect.ValueOf(&node).Elem())
+	if err != nil {
+		return nil, err
+	}
+
+	return json.Marshal(val)
+}
+
+// Decode a single node encoded by MarshalNode. Positions are given the
+// file name file.
+func UnmarshalNode(data []byte, file string) (Node, error) {
+	return unmarshalNode(data, file)
+}
+
+func unmarshalNode(data json.RawMessage, file string) (Node, error) {
+	var node Node
+
+	if err := decodeValue(data, reflect.ValueOf(&node).Elem(),
+		file); err != nil {
+		return nil, err
+	}
+
+	return node, nil
+}
+
+// Lower case the first letter of a field name.
+func jsonName(name string) string {
+	r, size := utf8.DecodeRuneInString(name)
+	return string(unicode.ToLower(r)) + name[size:]
+}
+
+func encodePosition(pos scanner.Position) *jsonPosition {
+	if !pos.IsValid() {
+		return nil
+	}
+
+	return &jsonPosition{pos.Offset, pos.Line, pos.Column}
+}
+
+// Convert val to something encoding/json will write in our format.
+func encodeValue(val reflect.Value) (interface{}, error) {
+	switch val.Kind() {
+	case reflect.Interface:
+		if val.IsNil() {
+			return nil, nil
+		}
+
+		node, ok := val.Interface().(Node)
+		if !ok {
+			return nil, fmt.Errorf("can't encode %v", val.Type())
+		}
+
+		kind := reflect.TypeOf(node).Name()
+		if nodeTypes[kind] == nil {
+			return nil, fmt.Errorf("can't encode node type %T", node)
+		}
+
+		obj, err := encodeFields(reflect.ValueOf(node))
+		if err != nil {
+			return nil, err
+		}
+
+		obj["kind"] = kind
+		obj["pos"] = encodePosition(node.Pos())
+		obj["end"] = encodePosition(node.End())
+
+		return obj, nil
+
+	case reflect.Slice:
+		if val.IsNil() {
+			return nil, nil
+		}
+
+		elems := make([]interface{}, val.Len())
+
+		for i := range elems {
+			elem, err := encodeValue(val.Index(i))
+			if err != nil {
+				return nil, err
+			}
+
+			elems[i] = elem
+		}
+
+		return elems, nil
+
+	case reflect.Struct:
+		return encodeFields(val)
+
+	case reflect.String, reflect.Bool, reflect.Int, reflect.Int64:
+		return val.Interface(), nil
+	}
+
+	return nil, fmt.Errorf("can't encode %v", val.Type())
+}
+
+// The exported fields of a struct, other than the span of a node.
+func encodeFields(val reflect.Value) (map[string]interface{}, error) {
+	obj := map[string]interface{}{}
+
+	for i := 0; i < val.NumField(); i++ {
+		field := val.Type().Field(i)
+
+		if field.PkgPath != "" || field.Type == reflect.TypeOf(Span{}) {
+			continue
+		}
+
+		enc, err := encodeValue(val.Field(i))
+		if err != nil {
+			return nil, fmt.Errorf("%s.%s: %v", val.Type().Name(),
+				field.Name, err)
+		}
+
+		obj[jsonName(field.Name)] = enc
+	}
+
+	return obj, nil
+}
+
+func decodePosition(data json.RawMessage, file string) (scanner.Position,
+	error) {
+
+	var pos *jsonPosition
+
+	if err := json.Unmarshal(data, &pos); err != nil || pos == nil {
+		return scanner.Position{}, err
+	}
+
+	return scanner.Position{Filename: file, Offset: pos.Offset,
+		Line: pos.Line, Column: pos.Column}, nil
+}
+
+// Decode data into the settable val, the inverse of encodeValue.
+func decodeValue(data json.RawMessage, val reflect.Value, file string) error {
+	if string(data) == "null" || len(data) == 0 {
+		val.Set(reflect.Zero(val.Type()))
+		return nil
+	}
+
+	switch val.Kind() {
+	case reflect.Interface:
+		var obj map[string]json.RawMessage
+		if err := json.Unmarshal(data, &obj); err != nil {
+			return err
+		}
+
+		var kind string
+		if err := json.Unmarshal(obj["kind"], &kind); err != nil {
+			return fmt.Errorf("node without a kind: %s", data)
+		}
+
+		typ, ok := nodeTypes[kind]
+		if !ok {
+			return fmt.Errorf("unknown node kind %q", kind)
+		}
+
+		node := reflect.New(typ).Elem()
+
+		if err := decodeFields(obj, node, file); err != nil {
+			return err
+		}
+
+		var span Span
+		var err error
+
+		if span.Start, err = decodePosition(obj["pos"], file); err != nil {
+			return err
+		}
+		if span.Stop, err = decodePosition(obj["end"], file); err != nil {
+			return err
+		}
+
+		node.FieldByName("Span").Set(reflect.ValueOf(span))
+		val.Set(node)
+
+		return nil
+
+	case reflect.Slice:
+		var elems []json.RawMessage
+		if err := json.Unmarshal(data, &elems); err != nil {
+			return err
+		}
+
+		slice := reflect.MakeSlice(val.Type(), len(elems), len(elems))
+
+		for i, elem := range elems {
+			if err := decodeValue(elem, slice.Index(i), file); err != nil {
+				return err
+			}
+		}
+
+		val.Set(slice)
+		return nil
+
+	case reflect.Struct:
+		var obj map[string]json.RawMessage
+		if err := json.Unmarshal(data, &obj); err != nil {
+			return err
+		}
+
+		return decodeFields(obj, val, file)
+	}
+
+	return json.Unmarshal(data, val.Addr().Interface())
+}
+
+func decodeFields(obj map[string]json.RawMessage, val reflect.Value,
+	file string) error {
+
+	for i := 0; i < val.NumField(); i++ {
+		field := val.Type().Field(i)
+
+		if field.PkgPath != "" || field.Type == reflect.TypeOf(Span{}) {
+			continue
+		}
+
+		if err := decodeValue(obj[jsonName(field.Name)], val.Field(i),
+			file); err != nil {
+			return fmt.Errorf("%s.%s: %v", val.Type().Name(), field.Name,
+				err)
+		}
+	}
+
+	return nil
+}
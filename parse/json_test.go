@@ -0,0 +1,160 @@
+package parse
+
+import (
+	"io/ioutil"
+	"reflect"
+	"strings"
+	"testing"
+)
+
+func TestJSONRoundTrip(t *testing.T) {
+	for _, test := range tests {
+		src, err := ioutil.ReadFile("../examples/" + test)
+		if err != nil {
+			t.Fatalf("failed to open test: %s", err)
+		}
+
+		unit, err := NewParser(test, strings.NewReader(string(src))).Parse()
+		if err != nil {
+			t.Fatalf("%s failed to parse: %v", test, err)
+		}
+
+		data, err := Marshal(unit)
+		if err != nil {
+			t.Fatalf("%s: Marshal failed: %v", test, err)
+		}
+
+		decoded, err := Unmarshal(data)
+		if err != nil {
+			t.Fatalf("%s: Unmarshal failed: %v", test, err)
+		}
+
+		if !reflect.DeepEqual(unit, decoded) {
+			t.Errorf("%s: round trip differs:\n%v\n%v", test, unit, decoded)
+		}
+	}
+
+	// Everything else the parser can produce
+	unit, _ := NewParser("x.b", strings.NewReader(`
+v[] 'ab', "s", main;
+e;
+main(a) {
+	extrn e;
+	auto x, y[2 * 2];
+l:	switch (a) { case -1: goto l; default: x = y[0] ? !a : a++; }
+	if (x =+ 1) return; else while (0) break;
+	$;
+}
+bad bad;`)).Parse()
+
+	data, err := Marshal(unit)
+	if err != nil {
+		t.Fatalf("Marshal failed: %v", err)
+	}
+
+	decoded, err := Unmarshal(data)
+	if err != nil {
+		t.Fatalf("Unmarshal failed: %v", err)
+	}
+
+	if !reflect.DeepEqual(unit, decoded) {
+		t.Errorf("round trip differs:\n%v\n%v", unit, decoded)
+	}
+}
+
+// A unit put together without Defs still has all of its declarations
+// encoded.
+func TestJSONWithoutDefs(t *testing.T) {
+	parsed, _ := NewParser("x.b", strings.NewReader(
+		"f() { return (v); } v 1;")).Parse()
+
+	unit := TranslationUnit{File: "x.b", Funcs: parsed.Funcs,
+		Vars: parsed.Vars}
+
+	data, err := Marshal(unit)
+	if err != nil {
+		t.Fatalf("Marshal failed: %v", err)
+	}
+
+	decoded, err := Unmarshal(data)
+	if err != nil {
+		t.Fatalf("Unmarshal failed: %v", err)
+	}
+
+	if !reflect.DeepEqual(decoded.Funcs, unit.Funcs) ||
+		!reflect.DeepEqual(decoded.Vars, unit.Vars) {
+		t.Errorf("round trip differs:\n%v\n%v", unit, decoded)
+	}
+
+	// Without Defs, externals come first
+	if len(decoded.Defs) != 2 || !reflect.DeepEqual(decoded.Defs[0],
+		unit.Vars[0]) {
+		t.Errorf("Expected v then f, got %v", decoded.Defs)
+	}
+}
+
+// The encoding is meant to be read by other tools, so changes to it
+// should be deliberate (and change JSONVersion).
+func TestJSONFormat(t *testing.T) {
+	unit, err := NewParser("f.b", strings.NewReader("f(a) return (a+1);")).Parse()
+	if err != nil {
+		t.Fatalf("Parse failed: %v", err)
+	}
+
+	data, err := Marshal(unit)
+	if err != nil {
+		t.Fatalf("Marshal failed: %v", err)
+	}
+
+	expect := `{"version":1,"file":"f.b","defs":[{"body":{"end":` +
+		`{"offset":18,"line":1,"column":19},"kind":"ReturnNode","node":` +
+		`{"end":{"offset":17,"line":1,"column":18},"kind":"ParenNode",` +
+		`"node":{"end":{"offset":16,"line":1,"column":17},"kind":` +
+		`"BinaryNode","left":{"end":{"offset":14,"line":1,"column":15},` +
+		`"kind":"IdentNode","pos":{"offset":13,"line":1,"column":14},` +
+		`"value":"a"},"oper":"+","pos":{"offset":13,"line":1,` +
+		`"column":14},"right":{"end":{"offset":16,"line":1,"column":17},` +
+		`"kind":"IntegerNode","pos":{"offset":15,"line":1,"column":16},` +
+		`"radix":10,"value":1}},"pos":{"offset":12,"line":1,"column":13}},` +
+		`"pos":{"offset":5,"line":1,"column":6}},"end":{"offset":18,` +
+		`"line":1,"column":19},"kind":"FunctionNode","name":"f","params":` +
+		`["a"],"pos":{"offset":0,"line":1,"column":1}}]}`
+
+	if string(data) != expect {
+		t.Errorf("Expected:\n%s\ngot:\n%s", expect, data)
+	}
+}
+
+func TestJSONErrors(t *testing.T) {
+	var bad = []string{
+		`{"version":2,"file":"","defs":[]}`,
+		`{"version":1,"file":"","defs":[{"kind":"NoSuchNode"}]}`,
+		`{"version":1,"file":"","defs":[{"name":"x"}]}`,
+		`{"version":1,"file":"","defs":[{"kind":"IdentNode","value":"x"}]}`,
+		`{"version":1,"file":"","defs":[{"kind":"ExternVarInitNode",` +
+			`"name":1}]}`,
+		`not json`,
+	}
+
+	for _, data := range bad {
+		if _, err := Unmarshal([]byte(data)); err == nil {
+			t.Errorf("%s: expected error", data)
+		}
+	}
+
+	// Nodes built by hand have no positions
+	node := BinaryNode{Left: IdentNode{Value: "a"}, Oper: "+",
+		Right: IntegerNode{Value: 1}}
+
+	data, err := MarshalNode(node)
+	if err != nil {
+		t.Fatalf("MarshalNode failed: %v", err)
+	}
+
+	decoded, err := UnmarshalNode(data, "")
+	if err != nil {
+		t.Fatalf("UnmarshalNode failed: %v", err)
+	} else if !reflect.DeepEqual(node, decoded) {
+		t.Errorf("Expected %v, got %v", node, decoded)
+	}
+}
@@ -1,6 +1,7 @@
 package parse
 
 import (
+	"bytes"
 	"container/list"
 	"fmt"
 	"io"
@@ -12,9 +13,20 @@ import (
 const eof int = -1
 
 type Lexer struct {
-	name      string
-	scanner   scanner.Scanner
-	lookahead *list.List
+	name       string
+	scanner    scanner.Scanner
+	lookahead  *list.List
+	pending    *Token // split off the end of the last token
+	keepTrivia bool   // attach whitespace and comments to tokens
+	errors     []error
+	scanErr    error        // reported by the scanner for the current token
+	src        bytes.Buffer // everything read so far, for token text
+}
+
+// A token along with the error from lexing it, if any
+type lexed struct {
+	tok Token
+	err error
 }
 
 var keywords = map[string]bool{
@@ -37,11 +49,11 @@ type LexError struct {
 }
 
 func (l *LexError) Error() string {
-	return fmt.Sprintf("Lex error on line: %d, character: %d: %s",
-		l.pos.Line, l.pos.Column, l.msg)
-
+	return fmt.Sprintf("%v: Lex error: %s", l.pos, l.msg)
 }
 
+func (l *LexError) Pos() scanner.Position { return l.pos }
+
 func NewLexError(pos scanner.Position, msg string) error {
 	return &LexError{pos, msg}
 }
@@ -52,87 +64,141 @@ func NewLexer(name string, input io.Reader) *Lexer {
 		lookahead: list.New(),
 	}
 
-	lex.scanner.Init(input)
+	lex.scanner.Init(io.TeeReader(input, &lex.src))
+	lex.scanner.Filename = name
 	lex.scanner.Mode = scanner.ScanIdents | scanner.ScanInts |
 		scanner.ScanStrings
 
+	lex.scanner.Error = func(s *scanner.Scanner, msg string) {
+		if lex.scanErr == nil {
+			lex.scanErr = NewLexError(s.Pos(), msg)
+		}
+	}
+
+	return lex
+}
+
+// A lexer which, rather than discarding whitespace and comments, attaches
+// them to the token which follows as trivia. Trailing whitespace and
+// comments at the end of the input are attached to the EOF token.
+func NewTriviaLexer(name string, input io.Reader) *Lexer {
+	lex := NewLexer(name, input)
+	lex.keepTrivia = true
+	lex.scanner.Whitespace = 0
+
 	return lex
 }
 
 func (lex *Lexer) PeekToken() (Token, error) {
 	tok, err := lex.lexToken()
 
-	if err != nil {
-		return tok.Error(), err
-	}
-
-	lex.lookahead.PushBack(tok)
-	return tok, nil
+	lex.lookahead.PushBack(lexed{tok, err})
+	return tok, err
 }
 
+// Errors are returned alongside an error token, and lexing carries on
+// from just after the bad input.
 func (lex *Lexer) NextToken() (Token, error) {
 	if lex.lookahead.Front() != nil {
 		node := lex.lookahead.Front()
-		tok := node.Value.(Token)
+		next := node.Value.(lexed)
 
 		lex.lookahead.Remove(node)
 
-		return tok, nil
+		return next.tok, next.err
 	}
 
 	return lex.lexToken()
 }
 
-func (lex *Lexer) lexToken() (tok Token, err error) {
-	tok = Token{
-		start: lex.scanner.Pos(),
-	}
+// Every error encountered so far, in the order they were found.
+func (lex *Lexer) Errors() []error {
+	return lex.errors
+}
 
-	// Remove error handler
-	defer func() { lex.scanner.Error = nil }()
+func (lex *Lexer) lexToken() (Token, error) {
+	tok, err := lex.scanToken()
 
-	// TODO: this is probably horrible style
-	defer func() { recover() }()
+	if err != nil {
+		lex.errors = append(lex.errors, err)
+	}
 
-	errorHandle := func(s *scanner.Scanner, msg string) {
-		tok = tok.Error()
-		err = NewLexError(lex.scanner.Pos(), msg)
+	return tok, err
+}
 
-		// Panic to get ourselves out of the parent func, this is
-		// probably terrible form
-		panic("ScanErrorHandle")
+func (lex *Lexer) scanToken() (tok Token, err error) {
+	if lex.pending != nil {
+		tok, lex.pending = *lex.pending, nil
+		return tok, nil
 	}
 
-	lex.scanner.Error = errorHandle
+	// The scanner may complain about any character it reads
+	lex.scanErr = nil
+	defer func() {
+		if err == nil && lex.scanErr != nil {
+			tok, err = tok.Error(), lex.scanErr
+		}
+
+		if err != nil {
+			tok.End = lex.scanner.Pos()
+		}
+
+		tok.Text = lex.sourceText(tok.Start, tok.End)
+	}()
+
+	var scan rune
+
+	for {
+		scan = lex.scanner.Scan()
+		tok.Start = lex.scanner.Position
 
-	scan := lex.scanner.Scan()
+		if isWhitespace(scan) {
+			// Only seen in trivia mode, the scanner skips it otherwise
+			tok.Trivia = append(tok.Trivia, lex.lexWhitespace(scan))
+		} else if scan == '/' && lex.scanner.Peek() == '*' {
+			comment, err := lex.lexComment()
+			if err != nil {
+				return tok.Error(), err
+			}
+
+			if lex.keepTrivia {
+				tok.Trivia = append(tok.Trivia, comment)
+			}
+		} else {
+			break
+		}
+	}
 
-	tok.value = lex.scanner.TokenText()
+	tok.Value = lex.scanner.TokenText()
+
+	if lex.scanErr != nil {
+		return tok.Error(), lex.scanErr
+	}
 
 	switch scan {
 	case scanner.EOF:
-		tok.kind = tkEof
+		tok.Kind = TokEof
 
 	case scanner.Int:
-		tok.kind = tkNumber
+		tok.Kind = TokNumber
 		// TODO: this isn't all inclusive
 		if next := lex.scanner.Peek(); unicode.IsLetter(next) {
 			lex.scanner.Scan() // run until end of token
 
 			err = NewLexError(
-				lex.scanner.Pos(),
-				fmt.Sprintf("bad number: %s%s", tok.value,
+				tok.Start,
+				fmt.Sprintf("bad number: %s%s", tok.Value,
 					lex.scanner.TokenText()))
 
 			return tok.Error(), err
 		}
 
 	case scanner.String:
-		tok.kind = tkString
+		tok.Kind = TokString
 		// cut out leading/trailing "
-		tok.value = tok.value[1 : len(tok.value)-1]
+		tok.Value = tok.Value[1 : len(tok.Value)-1]
 
-		if _, err := lex.checkEscapes(tok.value); err != nil {
+		if err := lex.checkEscapes(tok.Start, tok.Value); err != nil {
 			return tok.Error(), err
 		}
 
@@ -145,140 +211,234 @@ func (lex *Lexer) lexToken() (tok Token, err error) {
 		r := lex.scanner.Peek()
 		for strings.ContainsRune("_.", r) || unicode.IsLetter(r) || unicode.IsDigit(r) {
 
-			tok.value += string(lex.scanner.Next())
+			tok.Value += string(lex.scanner.Next())
 
 			r = lex.scanner.Peek()
 		}
 
-		if keywords[tok.value] {
-			tok.kind = tkKeyword
+		if keywords[tok.Value] {
+			tok.Kind = TokKeyword
 		} else {
-			tok.kind = tkIdent
+			tok.Kind = TokIdent
 		}
 
 	case '{':
-		tok.kind = tkOpenBrace
+		tok.Kind = TokOpenBrace
 
 	case '}':
-		tok.kind = tkCloseBrace
+		tok.Kind = TokCloseBrace
 
 	case '[':
-		tok.kind = tkOpenBracket
+		tok.Kind = TokOpenBracket
 
 	case ']':
-		tok.kind = tkCloseBracket
+		tok.Kind = TokCloseBracket
 
 	case '(':
-		tok.kind = tkOpenParen
+		tok.Kind = TokOpenParen
 
 	case ')':
-		tok.kind = tkCloseParen
+		tok.Kind = TokCloseParen
 
 	case ';':
-		tok.kind = tkSemicolon
+		tok.Kind = TokSemicolon
 
 	case ':':
-		tok.kind = tkColon
+		tok.Kind = TokColon
 
 	case ',':
-		tok.kind = tkComma
+		tok.Kind = TokComma
 
 	case '?':
-		tok.kind = tkTernary
+		tok.Kind = TokTernary
 
 	case '\'':
-		tok.kind = tkCharacter
-		tok.value = ""
+		tok.Kind = TokCharacter
+		tok.Value = ""
 
 	endstring:
 		for {
 			switch char := lex.scanner.Next(); char {
 			case '\n', scanner.EOF:
-				return tok.Error(), NewLexError(lex.scanner.Pos(),
+				return tok.Error(), NewLexError(tok.Start,
 					fmt.Sprintf("unterminated character: %s",
-						tok.value))
+						tok.Value))
 			case '\'':
 				break endstring
 			default:
-				tok.value += string(char)
+				tok.Value += string(char)
 			}
 		}
 
-		numChars, err := lex.checkEscapes(tok.value)
-		if err != nil {
+		// How many characters fit depends on the target's word
+		// size, which the parser checks.
+		if err := lex.checkEscapes(tok.Start, tok.Value); err != nil {
 			return tok.Error(), err
 		}
 
-		if numChars > 4 {
-			return tok.Error(), NewLexError(lex.scanner.Pos(),
-				fmt.Sprintf("oversized character literal: %s",
-					tok.value))
-		}
-
 	case '/':
-		if lex.scanner.Peek() == '*' {
-			lex.scanner.Next() // eat '*'
-		endcomment:
-			for {
-				switch char := lex.scanner.Next(); char {
-				case scanner.EOF:
-					return tok.Error(),
-						NewLexError(lex.scanner.Pos(),
-							"unterminated comment")
-				case '*':
-					if lex.scanner.Peek() == '/' {
-						lex.scanner.Next()
-						break endcomment
-					}
-				}
-			}
-
-			return lex.NextToken()
-		} else {
-			tok.kind = tkOperator
-		}
+		tok.Kind = TokOperator
 
 	case '*':
 		if lex.scanner.Peek() == '/' {
 			lex.scanner.Next() // eat '/'
-			return tok.Error(), NewLexError(lex.scanner.Pos(),
+			return tok.Error(), NewLexError(tok.Start,
 				"unexpected end of comment")
 		} else {
-			tok.kind = tkOperator
+			tok.Kind = TokOperator
 		}
 
-		// XXX: some other operators still unhandled
-	case '=', '>', '<', '!':
-		tok.kind = tkOperator
-		if lex.scanner.Peek() == '=' {
-			tok.value += string(lex.scanner.Next())
+	case '=':
+		tok.Kind = TokOperator
+		lex.lexAssignment(&tok)
+
+	case '>', '<':
+		tok.Kind = TokOperator
 
+		// >=, <=, >> or <<
+		if next := lex.scanner.Peek(); next == '=' || next == scan {
+			tok.Value += string(lex.scanner.Next())
+		}
+
+	case '!':
+		tok.Kind = TokOperator
+		if lex.scanner.Peek() == '=' {
+			tok.Value += string(lex.scanner.Next())
 		}
 
 	case '+', '-':
-		tok.kind = tkOperator
+		tok.Kind = TokOperator
 
 		// ++ or --
-		if tok.value == string(lex.scanner.Peek()) {
+		if tok.Value == string(lex.scanner.Peek()) {
 			lex.scanner.Next()
-			tok.value += tok.value
+			tok.Value += tok.Value
 
 		}
 
-	case '%', '&', '~':
-		tok.kind = tkOperator
+	case '%', '&', '~', '|', '^':
+		tok.Kind = TokOperator
 
 	default:
-		return tok.Error(), NewLexError(lex.scanner.Pos(),
+		return tok.Error(), NewLexError(tok.Start,
 			fmt.Sprintf("unexpected character: %c", scan))
 
 	}
 
-	tok.end = lex.scanner.Pos()
+	if lex.pending == nil {
+		tok.End = lex.scanner.Pos()
+	}
 
 	return tok, nil
 }
 
+// The source between two positions in the input
+func (lex *Lexer) sourceText(start, end scanner.Position) string {
+	src := lex.src.Bytes()
+
+	if start.Offset < 0 || start.Offset > end.Offset || end.Offset > len(src) {
+		return ""
+	}
+
+	return string(src[start.Offset:end.Offset])
+}
+
+func isWhitespace(r rune) bool {
+	return r == ' ' || r == '\t' || r == '\n' || r == '\r'
+}
+
+// Collect a run of whitespace, the first character of which has just
+// been scanned.
+func (lex *Lexer) lexWhitespace(first rune) Trivia {
+	trivia := Trivia{
+		Kind:  Whitespace,
+		Text:  string(first),
+		Start: lex.scanner.Position,
+	}
+
+	for isWhitespace(lex.scanner.Peek()) {
+		trivia.Text += string(lex.scanner.Next())
+	}
+
+	trivia.End = lex.scanner.Pos()
+	return trivia
+}
+
+// Collect a comment, the opening '/' of which has just been scanned.
+// B comments do not nest.
+func (lex *Lexer) lexComment() (Trivia, error) {
+	// Next invalidates the scanner's position, so take it first
+	trivia := Trivia{Kind: Comment, Start: lex.scanner.Position}
+	trivia.Text = "/" + string(lex.scanner.Next())
+
+	for {
+		switch char := lex.scanner.Next(); char {
+		case scanner.EOF:
+			return trivia, NewLexError(trivia.Start,
+				"unterminated comment")
+		case '*':
+			trivia.Text += string(char)
+
+			if lex.scanner.Peek() == '/' {
+				trivia.Text += string(lex.scanner.Next())
+				trivia.End = lex.scanner.Pos()
+				return trivia, nil
+			}
+		default:
+			trivia.Text += string(char)
+		}
+	}
+}
+
+// Finish lexing an operator starting with '='. B spells its assignment
+// operators with the '=' first (=+, =<<, ===, ...), and like the
+// original compiler we always take the longest match, so `x =-1` is
+// `x =- 1`.
+func (lex *Lexer) lexAssignment(tok *Token) {
+	switch lex.scanner.Peek() {
+	case '=':
+		// == or ===
+		tok.Value += string(lex.scanner.Next())
+
+		if lex.scanner.Peek() == '=' {
+			tok.Value += string(lex.scanner.Next())
+		}
+
+	case '+', '-', '*', '/', '%', '&', '|', '^':
+		tok.Value += string(lex.scanner.Next())
+
+	case '<', '>':
+		// =<, =<=, =<<, =>, =>=, =>>
+		op := lex.scanner.Next()
+		tok.Value += string(op)
+
+		if next := lex.scanner.Peek(); next == '=' || next == op {
+			tok.Value += string(lex.scanner.Next())
+		}
+
+	case '!':
+		// =!= is an operator, but =! is really = followed by a
+		// unary !, which has to be handed back as its own token.
+		pos := lex.scanner.Pos()
+		lex.scanner.Next()
+
+		if lex.scanner.Peek() == '=' {
+			lex.scanner.Next()
+			tok.Value += "!="
+			return
+		}
+
+		tok.End = pos
+		lex.pending = &Token{
+			Kind:  TokOperator,
+			Value: "!",
+			Text:  "!",
+			Start: pos,
+			End:   lex.scanner.Pos(),
+		}
+	}
+}
+
 // *0	null
 // *e	end-of-file
 // *(	{
@@ -288,30 +448,95 @@ func (lex *Lexer) lexToken() (tok Token, err error) {
 // *'	'
 // *"	"
 // *n	new line
-func (lex *Lexer) checkEscapes(str string) (int, error) {
-	escaped := ""
-
-	if str[len(str)-1] == '*' {
-		return -1, NewLexError(lex.scanner.Pos(), "invalid escape sequence")
-	}
-
-	numChars := 0
-
+func (lex *Lexer) checkEscapes(pos scanner.Position, str string) error {
 	for i := 0; i < len(str); i++ {
 		if str[i] == '*' {
+			if i+1 == len(str) {
+				return NewLexError(pos,
+					"invalid escape sequence")
+			}
+
 			switch str[i+1] {
 			case '0', 'e', '(', ')', 't', '*', '\'', '"', 'n':
 			default:
-				return -1, NewLexError(lex.scanner.Pos(), fmt.Sprintf("invalid escape: %c", str[i+1]))
+				return NewLexError(pos, fmt.Sprintf("invalid escape: %c", str[i+1]))
 			}
 
 			i += 1
-		} else {
-			escaped += string(str[i])
+		}
+	}
+
+	return nil
+}
+
+// Decode the escape sequences in a string or character constant which
+// has already been through checkEscapes.
+func unescape(str string) []byte {
+	decoded := make([]byte, 0, len(str))
+
+	for i := 0; i < len(str); i++ {
+		if str[i] != '*' || i+1 >= len(str) {
+			decoded = append(decoded, str[i])
+			continue
+		}
+
+		i += 1
+
+		switch str[i] {
+		case '0':
+			decoded = append(decoded, 0)
+		case 'e':
+			decoded = append(decoded, 4) // ASCII EOT
+		case '(':
+			decoded = append(decoded, '{')
+		case ')':
+			decoded = append(decoded, '}')
+		case 't':
+			decoded = append(decoded, '\t')
+		case 'n':
+			decoded = append(decoded, '\n')
+		default: // '*', '\'', '"'
+			decoded = append(decoded, str[i])
+		}
+	}
+
+	return decoded
+}
+
+// Lex the whole of input, up to and including the EOF token. Whitespace
+// and comments are kept as trivia, so Untokenize can reproduce the input
+// exactly. Tokens which could not be lexed are kept, as TokError.
+func Tokenize(name string, input io.Reader) ([]Token, error) {
+	lex := NewTriviaLexer(name, input)
+	toks := []Token{}
+
+	for {
+		tok, _ := lex.NextToken()
+		toks = append(toks, tok)
+
+		if tok.Kind == TokEof {
+			break
+		}
+	}
+
+	if errs := lex.Errors(); len(errs) > 0 {
+		return toks, ErrorList(errs)
+	}
+
+	return toks, nil
+}
+
+// The source text of a token stream, trivia included.
+func Untokenize(toks []Token) string {
+	var buf bytes.Buffer
+
+	for _, tok := range toks {
+		for _, trivia := range tok.Trivia {
+			buf.WriteString(trivia.Text)
 		}
 
-		numChars += 1
+		buf.WriteString(tok.Text)
 	}
 
-	return numChars, nil
+	return buf.String()
 }
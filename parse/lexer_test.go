@@ -13,32 +13,32 @@ func TestLexSanity(t *testing.T) {
 	}
 
 	tok, err := lex.PeekToken()
-	if err != nil || tok.kind != tkIdent || tok.value != "a" {
+	if err != nil || tok.Kind != TokIdent || tok.Value != "a" {
 		t.Errorf("PeekToken: %v", tok)
 	}
 
 	tok, err = lex.PeekToken()
-	if err != nil || tok.kind != tkIdent || tok.value != "b" {
+	if err != nil || tok.Kind != TokIdent || tok.Value != "b" {
 		t.Errorf("Double PeekToken: %v", tok)
 	}
 
 	tok, err = lex.NextToken()
-	if err != nil || tok.kind != tkIdent || tok.value != "a" {
+	if err != nil || tok.Kind != TokIdent || tok.Value != "a" {
 		t.Errorf("NextToken after peek: %v", tok)
 	}
 
 	tok, err = lex.NextToken()
-	if err != nil || tok.kind != tkIdent || tok.value != "b" {
+	if err != nil || tok.Kind != TokIdent || tok.Value != "b" {
 		t.Errorf("NextToken after second peek: %v", tok)
 	}
 
 	tok, err = lex.NextToken()
-	if err == nil || tok.kind != tkError {
+	if err == nil || tok.Kind != TokError {
 		t.Errorf("Bad input: %v", tok)
 	}
 
 	tok, err = lex.NextToken()
-	if err != nil || tok.kind != tkEof {
+	if err != nil || tok.Kind != TokEof {
 		t.Errorf("Eof: %v", tok)
 	}
 }
@@ -54,57 +54,57 @@ other_ident.123__
 	lex := NewLexer("file", in)
 
 	tok, err := lex.NextToken()
-	if err != nil || tok.kind != tkNumber || tok.value != "123" {
+	if err != nil || tok.Kind != TokNumber || tok.Value != "123" {
 		t.Errorf("Number: %v", tok)
 	}
 
 	tok, err = lex.NextToken()
-	if err != nil || tok.kind != tkString || tok.value != "a string with spaces" {
+	if err != nil || tok.Kind != TokString || tok.Value != "a string with spaces" {
 		t.Errorf("String: %v", tok)
 	}
 
 	tok, err = lex.NextToken()
-	if err != nil || tok.kind != tkIdent || tok.value != "an_identifier_1" {
+	if err != nil || tok.Kind != TokIdent || tok.Value != "an_identifier_1" {
 		t.Errorf("Ident: %v", tok)
 	}
 
 	tok, err = lex.NextToken()
-	if err != nil || tok.kind != tkKeyword || tok.value != "auto" {
+	if err != nil || tok.Kind != TokKeyword || tok.Value != "auto" {
 		t.Errorf("Keyword: %v, %v", tok, err)
 	}
 
 	tok, err = lex.NextToken()
-	if err != nil || tok.kind != tkIdent || tok.value != "auto_" {
+	if err != nil || tok.Kind != TokIdent || tok.Value != "auto_" {
 		t.Errorf("Not keyword: %v, %v", tok, err)
 	}
 
 	tok, err = lex.NextToken()
-	if err != nil || tok.kind != tkIdent || tok.value != "other_ident.123__" {
+	if err != nil || tok.Kind != TokIdent || tok.Value != "other_ident.123__" {
 		t.Errorf("Punctatuated ident: %v, %v", tok, err)
 	}
 
 	tok, err = lex.NextToken()
-	if err != nil || tok.kind != tkCharacter || tok.value != "char" {
+	if err != nil || tok.Kind != TokCharacter || tok.Value != "char" {
 		t.Errorf("Character: %v, %v", tok, err)
 	}
 
 	tok, err = lex.NextToken()
-	if err != nil || tok.kind != tkCharacter || tok.value != "ch" {
+	if err != nil || tok.Kind != TokCharacter || tok.Value != "ch" {
 		t.Errorf("Short character: %v, %v", tok, err)
 	}
 
 	tok, err = lex.NextToken()
-	if err != nil || tok.kind != tkCharacter || tok.value != "" {
+	if err != nil || tok.Kind != TokCharacter || tok.Value != "" {
 		t.Errorf("Empty character: %v, %v", tok, err)
 	}
 }
 
 func TestEscapeSequences(t *testing.T) {
-	in := strings.NewReader(` '*(*)*t*n' '*bad' 'bad*(*('`)
+	in := strings.NewReader(` '*(*)*t*n' '*bad' 'bad*q'`)
 	lex := NewLexer("file", in)
 
 	tok, err := lex.NextToken()
-	if err != nil || tok.kind != tkCharacter || tok.value != "*(*)*t*n" {
+	if err != nil || tok.Kind != TokCharacter || tok.Value != "*(*)*t*n" {
 		t.Errorf("escapes: %v %v", tok, err)
 	}
 
@@ -124,51 +124,80 @@ func TestLexOp(t *testing.T) {
 	lex := NewLexer("", strings.NewReader(`> = >= + ++ ---`))
 
 	tok, err := lex.NextToken()
-	if err != nil || tok.kind != tkOperator || tok.value != ">" {
+	if err != nil || tok.Kind != TokOperator || tok.Value != ">" {
 		t.Errorf("GT: %v, %v", tok, err)
 	}
 
 	tok, err = lex.NextToken()
-	if err != nil || tok.kind != tkOperator || tok.value != "=" {
+	if err != nil || tok.Kind != TokOperator || tok.Value != "=" {
 		t.Errorf("EQ: %v, %v", tok, err)
 	}
 
 	tok, err = lex.NextToken()
-	if err != nil || tok.kind != tkOperator || tok.value != ">=" {
+	if err != nil || tok.Kind != TokOperator || tok.Value != ">=" {
 		t.Errorf("GTE: %v, %v", tok, err)
 	}
 
 	tok, err = lex.NextToken()
-	if err != nil || tok.kind != tkOperator || tok.value != "+" {
+	if err != nil || tok.Kind != TokOperator || tok.Value != "+" {
 		t.Errorf("Plus: %v, %v", tok, err)
 	}
 
 	tok, err = lex.NextToken()
-	if err != nil || tok.kind != tkOperator || tok.value != "++" {
+	if err != nil || tok.Kind != TokOperator || tok.Value != "++" {
 		t.Errorf("Inc: %v, %v", tok, err)
 	}
 
 	tok, err = lex.NextToken()
-	if err != nil || tok.kind != tkOperator || tok.value != "--" {
+	if err != nil || tok.Kind != TokOperator || tok.Value != "--" {
 		t.Errorf("Dec: %v, %v", tok, err)
 	}
 
 	tok, err = lex.NextToken()
-	if err != nil || tok.kind != tkOperator || tok.value != "-" {
+	if err != nil || tok.Kind != TokOperator || tok.Value != "-" {
 		t.Errorf("Minus: %v, %v", tok, err)
 	}
 
 }
 
+func TestLexAllOps(t *testing.T) {
+	ops := []string{
+		"*", "/", "%", "+", "-", "<<", ">>", "<", "<=", ">", ">=",
+		"==", "!=", "&", "^", "|", "!", "~", "++", "--",
+		"=", "=+", "=-", "=*", "=/", "=%", "=<<", "=>>", "=&", "=|",
+		"=^", "===", "=!=", "=<", "=<=", "=>", "=>=",
+	}
+
+	lex := NewLexer("", strings.NewReader(strings.Join(ops, " ")))
+
+	for _, op := range ops {
+		tok, err := lex.NextToken()
+		if err != nil || tok.Kind != TokOperator || tok.Value != op {
+			t.Errorf("Expected %s, got: %v, %v", op, tok, err)
+		}
+	}
+
+	// B takes the longest match, except that there is no =! operator
+	lex = NewLexer("", strings.NewReader(`j=-1 a=!b a=!=b`))
+
+	var expect = []string{"j", "=-", "1", "a", "=", "!", "b", "a", "=!=", "b"}
+
+	for _, str := range expect {
+		if tok, err := lex.NextToken(); err != nil || tok.Value != str {
+			t.Errorf("Expected %s, got: %v, %v", str, tok, err)
+		}
+	}
+}
+
 func TestComment(t *testing.T) {
 	lex := NewLexer("",
 		strings.NewReader(`1 /* comment * /* (no nesting) */ 2`))
 
-	if tok, err := lex.NextToken(); err != nil || tok.value != "1" {
+	if tok, err := lex.NextToken(); err != nil || tok.Value != "1" {
 		t.Errorf("Comment (pre): %v, %v", tok, err)
 	}
 
-	if tok, err := lex.NextToken(); err != nil || tok.value != "2" {
+	if tok, err := lex.NextToken(); err != nil || tok.Value != "2" {
 		t.Errorf("Comment (post): %v, %v", tok, err)
 	}
 }
@@ -178,49 +207,201 @@ func TestExceptional(t *testing.T) {
 	lex := NewLexer("", strings.NewReader(`"unterminated string`))
 
 	tok, err := lex.NextToken()
-	if err == nil || tok.kind != tkError {
+	if err == nil || tok.Kind != TokError {
 		t.Errorf("Unterminated: %v", tok)
 	}
 
 	lex = NewLexer("", strings.NewReader(`123abc xyz`))
 
 	tok, err = lex.NextToken()
-	if err == nil || tok.kind != tkError {
+	if err == nil || tok.Kind != TokError {
 		t.Errorf("Bad number: %v", tok)
 	}
 
 	tok, err = lex.NextToken()
-	if err != nil || tok.kind != tkIdent || tok.value != "xyz" {
+	if err != nil || tok.Kind != TokIdent || tok.Value != "xyz" {
 		t.Errorf("Token after bad number: %v, %v", tok, err)
 	}
 
 	lex = NewLexer("", strings.NewReader(`123 abc`))
 	tok, err = lex.NextToken()
-	if err != nil || tok.kind != tkNumber {
+	if err != nil || tok.Kind != TokNumber {
 		t.Errorf("Good number: %v, %v", tok, err)
 	}
 
+	// How many characters fit in a word is up to the parser's target
 	lex = NewLexer("", strings.NewReader(`'oversizedchar' 'unterminated`))
 	tok, err = lex.NextToken()
-	if err == nil || tok.kind != tkError {
-		t.Errorf("Oversized character: %v", tok)
+	if err != nil || tok.Kind != TokCharacter {
+		t.Errorf("Oversized character: %v, %v", tok, err)
 	}
 
 	tok, err = lex.NextToken()
-	if err == nil || tok.kind != tkError {
+	if err == nil || tok.Kind != TokError {
 		t.Errorf("Unterminated character: %v", tok)
 	}
 
 	lex = NewLexer("", strings.NewReader(`*/ /* unterminated`))
 	// Because Emacs' syntax highlighter is silly: "*/"
 	tok, err = lex.NextToken()
-	if err == nil || tok.kind != tkError {
+	if err == nil || tok.Kind != TokError {
 		t.Errorf("Unmatched end of comment: %v", tok)
 	}
 
 	tok, err = lex.NextToken()
-	if err == nil || tok.kind != tkError {
+	if err == nil || tok.Kind != TokError {
 		t.Errorf("Unterminated comment: %v", tok)
 	}
 
 }
+
+func TestLexTrivia(t *testing.T) {
+	src := "/* head */\nmain ( ) {\n\tx; /* trailing */\n}\n"
+	lex := NewTriviaLexer("", strings.NewReader(src))
+
+	var expect = []struct {
+		value  string
+		trivia []Trivia
+	}{
+		{"main", []Trivia{{Kind: Comment, Text: "/* head */"},
+			{Kind: Whitespace, Text: "\n"}}},
+		{"(", []Trivia{{Kind: Whitespace, Text: " "}}},
+		{")", []Trivia{{Kind: Whitespace, Text: " "}}},
+		{"{", []Trivia{{Kind: Whitespace, Text: " "}}},
+		{"x", []Trivia{{Kind: Whitespace, Text: "\n\t"}}},
+		{";", nil},
+		{"}", []Trivia{{Kind: Whitespace, Text: " "},
+			{Kind: Comment, Text: "/* trailing */"},
+			{Kind: Whitespace, Text: "\n"}}},
+		{"", []Trivia{{Kind: Whitespace, Text: "\n"}}},
+	}
+
+	restored := ""
+
+	for _, e := range expect {
+		tok, err := lex.NextToken()
+		if err != nil || tok.Value != e.value {
+			t.Errorf("Expected %s, got: %v, %v", e.value, tok, err)
+		}
+
+		if len(tok.Trivia) != len(e.trivia) {
+			t.Errorf("%v: expected trivia %v, got %v", tok, e.trivia,
+				tok.Trivia)
+			continue
+		}
+
+		for i, trivia := range tok.Trivia {
+			if trivia.Kind != e.trivia[i].Kind ||
+				trivia.Text != e.trivia[i].Text {
+				t.Errorf("%v: expected trivia %v, got %v", tok,
+					e.trivia[i], trivia)
+			}
+
+			restored += trivia.Text
+		}
+
+		restored += tok.Value
+	}
+
+	if restored != src {
+		t.Errorf("Restored source differs: %q", restored)
+	}
+
+	// Trivia positions cover exactly their text
+	lex = NewTriviaLexer("", strings.NewReader("a /* x\ny */ b"))
+	lex.NextToken()
+
+	tok, _ := lex.NextToken()
+	comment := tok.Trivia[1]
+	if comment.Start.Line != 1 || comment.Start.Column != 3 ||
+		comment.End.Line != 2 ||
+		comment.End.Column != 5 {
+		t.Errorf("Comment position: %v - %v", comment.Start, comment.End)
+	}
+
+	// Comments are still skipped without trivia mode, even when a
+	// token has already been peeked at.
+	lex = NewLexer("", strings.NewReader("a /* 1 */ b /* 2 */ c"))
+	lex.PeekToken()
+	lex.PeekToken()
+
+	for _, str := range []string{"a", "b", "c"} {
+		if tok, err := lex.NextToken(); err != nil || tok.Value != str {
+			t.Errorf("Expected %s, got: %v, %v", str, tok, err)
+		}
+	}
+}
+
+// The lexer reports an error token and carries on
+func TestLexErrorRecovery(t *testing.T) {
+	lex := NewLexer("bad.b", strings.NewReader(`a = "bad *q escape";
+b = 'unterminated
+c = $ d;
+"unterminated`))
+
+	var expect = []struct {
+		kind  TokenType
+		value string
+	}{
+		{TokIdent, "a"}, {TokOperator, "="}, {TokError, ""},
+		{TokSemicolon, ";"}, {TokIdent, "b"}, {TokOperator, "="},
+		{TokError, ""}, {TokIdent, "c"}, {TokOperator, "="}, {TokError, ""},
+		{TokIdent, "d"}, {TokSemicolon, ";"}, {TokError, ""}, {TokEof, ""},
+	}
+
+	for _, e := range expect {
+		tok, err := lex.NextToken()
+		if tok.Kind != e.kind || (e.value != "" && tok.Value != e.value) {
+			t.Errorf("Expected %v %s, got: %v", e.kind, e.value, tok)
+		}
+
+		if (err != nil) != (e.kind == TokError) {
+			t.Errorf("%v: unexpected error state: %v", tok, err)
+		}
+	}
+
+	errs := lex.Errors()
+	if len(errs) != 4 {
+		t.Fatalf("Expected 4 errors, got: %v", errs)
+	}
+
+	for i, line := range []int{1, 2, 3, 4} {
+		if pos := errs[i].(*LexError).Pos(); pos.Line != line ||
+			pos.Filename != "bad.b" {
+			t.Errorf("Error %d position: %v", i, pos)
+		}
+	}
+}
+
+func TestTokenize(t *testing.T) {
+	src := `/* greet */
+main() {
+	printf("hi*n", 'ab');  /* done */
+	x =! $y;
+}
+`
+	toks, err := Tokenize("greet.b", strings.NewReader(src))
+	if errs, ok := err.(ErrorList); !ok || len(errs) != 1 {
+		t.Errorf("Expected one error, got: %v", err)
+	}
+
+	if str := Untokenize(toks); str != src {
+		t.Errorf("Restored source differs:\n%s", str)
+	}
+
+	if last := toks[len(toks)-1]; last.Kind != TokEof {
+		t.Errorf("Expected EOF last, got: %v", last)
+	}
+
+	var str Token
+	for _, tok := range toks {
+		if tok.Kind == TokString {
+			str = tok
+		}
+	}
+
+	if str.Value != "hi*n" || str.Text != `"hi*n"` || str.Start.Line != 3 ||
+		str.Start.Column != 9 || str.End.Column != 15 {
+		t.Errorf("String token: %#v", str)
+	}
+}
@@ -1,10 +1,13 @@
 package parse
 
 import (
+	"bytes"
 	"fmt"
 	"io"
+	"sort"
 	"strconv"
 	"strings"
+	"text/scanner"
 )
 
 type ParseError struct {
@@ -13,89 +16,309 @@ type ParseError struct {
 }
 
 func (p *ParseError) Error() string {
-	return fmt.Sprintf("Parse error on line %d, at token: %s: %s",
-		p.tok.start.Line, p.tok.String(), p.msg)
+	return fmt.Sprintf("%v: Parse error at token: %s: %s",
+		p.tok.Start, p.tok.String(), p.msg)
 }
 
+func (p *ParseError) Pos() scanner.Position { return p.tok.Start }
+
 func NewParseError(tok Token, msg string) error {
 	return &ParseError{tok, msg}
 }
 
+// Several errors, ordered by where they occurred in the source.
+type ErrorList []error
+
+func (e ErrorList) Error() string {
+	msgs := make([]string, len(e), len(e))
+
+	for i, err := range e {
+		msgs[i] = err.Error()
+	}
+
+	return strings.Join(msgs, "\n")
+}
+
+func (e ErrorList) Len() int      { return len(e) }
+func (e ErrorList) Swap(i, j int) { e[i], e[j] = e[j], e[i] }
+func (e ErrorList) Less(i, j int) bool {
+	a, b := errorPos(e[i]), errorPos(e[j])
+
+	if a.Line != b.Line {
+		return a.Line < b.Line
+	}
+
+	return a.Column < b.Column
+}
+
+func errorPos(err error) scanner.Position {
+	if p, ok := err.(interface {
+		Pos() scanner.Position
+	}); ok {
+		return p.Pos()
+	}
+
+	return scanner.Position{}
+}
+
 type Parser struct {
+	Target Target
+
 	lex    *Lexer
 	tokens []Token
 	tokIdx int
 	nodes  []Node
+	errors ErrorList
 }
 
 func NewParser(name string, input io.Reader) *Parser {
 	parse := &Parser{
+		Target: DefaultTarget,
 		lex:    NewLexer(name, input),
 		nodes:  make([]Node, 0, 10),
 		tokens: make([]Token, 0, 10),
 		tokIdx: -1,
 	}
 
-	if _, err := parse.nextToken(); err != nil {
-		panic(err)
-	}
+	parse.nextToken()
 
 	return parse
 }
 
-func (p *Parser) Parse() (unit TranslationUnit, err error) {
-	var node *Node = nil
-	unit = TranslationUnit{File: p.lex.name}
+// Readers for the src arguments of the Parse* functions below, which
+// may be a string, []byte or io.Reader.
+func sourceReader(src interface{}) (io.Reader, error) {
+	switch s := src.(type) {
+	case string:
+		return strings.NewReader(s), nil
+	case []byte:
+		return bytes.NewReader(s), nil
+	case io.Reader:
+		return s, nil
+	}
 
-	// Bail out of lex errors
-	// TODO: this is sort of convoluted logic, refactor
-	defer func() {
-		if e := recover(); e != nil {
-			// if it's a lex error, trap, return
-			if lexErr, ok := e.(*LexError); ok {
-				unit, err = TranslationUnit{}, lexErr
-			} else {
-				// rethrow
-				panic(e)
-			}
+	return nil, fmt.Errorf("invalid source type %T", src)
+}
+
+// Parse a single expression, such as `a[i] =+ f(x)`.
+func ParseExpr(name string, src interface{}) (Node, error) {
+	return parseFragment(name, src, (*Parser).parseExpression)
+}
+
+// Parse a single statement. Errors in statements nested inside a block
+// are recovered from as in Parse, so a node may be returned along with
+// an error.
+func ParseStmt(name string, src interface{}) (Node, error) {
+	return parseFragment(name, src, (*Parser).parseStatement)
+}
+
+// Parse a single function definition or external variable.
+func ParseTopLevel(name string, src interface{}) (Node, error) {
+	return parseFragment(name, src, (*Parser).parseTopLevel)
+}
+
+// Run parse over the whole of src, which must contain nothing but the
+// one fragment.
+func parseFragment(name string, src interface{},
+	parse func(*Parser) (*Node, error)) (Node, error) {
+
+	input, err := sourceReader(src)
+	if err != nil {
+		return nil, err
+	}
+
+	p := NewParser(name, input)
+
+	node, err := parse(p)
+	if err != nil {
+		return nil, p.errorList(err)
+	}
+
+	if p.token().Kind != TokEof {
+		return *node, p.errorList(NewParseError(p.token(),
+			"unexpected input after end of fragment"))
+	}
+
+	return *node, p.errorList(nil)
+}
+
+// Parse the whole input. Syntax errors don't stop the parse: the bad
+// region is skipped and parsing picks up again at the next statement or
+// top level declaration. The unit returned holds whatever parsed, and
+// every error, lexical ones included, is returned as an ErrorList.
+func (p *Parser) Parse() (TranslationUnit, error) {
+	unit := TranslationUnit{File: p.lex.name}
+
+	for _, def := range p.parseDefinitions() {
+		switch def.(type) {
+		case FunctionNode:
+			unit.Funcs = append(unit.Funcs, def.(FunctionNode))
+		case BadNode:
+			unit.Bad = append(unit.Bad, def.(BadNode))
+		default:
+			unit.Vars = append(unit.Vars, def)
 		}
-	}()
+
+		unit.Defs = append(unit.Defs, def)
+	}
+
+	return unit, p.errorList(nil)
+}
+
+// Every top level declaration up to the end of input, in source order,
+// with a BadNode standing in for each that failed to parse.
+func (p *Parser) parseDefinitions() []Node {
+	var defs []Node
 
 	for {
-		if _, ok := p.acceptType(tkEof); ok {
+		// Nothing before a top level declaration is ever looked
+		// at again, so there's no need to hold on to its tokens.
+		p.discardTokens()
+
+		if _, ok := p.acceptType(TokEof); ok {
 			break
 		}
 
-		if node, err = p.parseTopLevel(); err != nil {
-			return unit, err
+		start := p.tokIdx
+
+		node, err := p.parseTopLevel()
+		if err != nil {
+			p.errors = append(p.errors, err)
+			p.syncTopLevel(start)
+
+			defs = append(defs, BadNode{p.spanFrom(start)})
+			continue
 		}
 
 		switch (*node).(type) {
-		case FunctionNode:
-			unit.Funcs = append(unit.Funcs, (*node).(FunctionNode))
-		case ExternVarInitNode, ExternVecInitNode:
-			unit.Vars = append(unit.Vars, *node)
+		case FunctionNode, ExternVarInitNode, ExternVecInitNode:
+			defs = append(defs, *node)
 		default:
-			return unit, NewParseError(p.token(),
-				"That's not a top level decl")
+			p.errors = append(p.errors, NewParseError(p.tokenAt(start),
+				"That's not a top level decl"))
+			defs = append(defs, BadNode{p.spanFrom(start)})
 		}
 	}
 
-	return unit, nil
+	return defs
+}
+
+// Drop the tokens before the current one.
+func (p *Parser) discardTokens() {
+	p.tokens = append(p.tokens[:0], p.tokens[p.tokIdx:]...)
+	p.tokIdx = 0
+}
+
+// Combine the errors recovered from so far and err (which may be nil)
+// with the lexer's errors. The rest of the input is lexed first so that
+// no lexical error goes unreported.
+func (p *Parser) errorList(err error) error {
+	for p.token().Kind != TokEof {
+		p.nextToken()
+	}
+
+	errs := append(ErrorList{}, p.lex.Errors()...)
+	errs = append(errs, p.errors...)
+
+	if err != nil {
+		errs = append(errs, err)
+	}
+
+	if len(errs) == 0 {
+		return nil
+	}
+
+	sort.Stable(errs)
+	return errs
+}
+
+// Skip the rest of a broken statement: up to and including the next `;`,
+// or up to the `}` closing the enclosing block. Braces opened along the
+// way are skipped as a unit, so a bad `if` or `while` header takes its
+// body with it. At least one token is always consumed unless the
+// parser is already at a `}` or the end of input.
+func (p *Parser) syncStatement() {
+	depth := 0
+
+	for {
+		switch p.token().Kind {
+		case TokEof:
+			return
+		case TokSemicolon:
+			if depth == 0 {
+				p.nextToken()
+				return
+			}
+		case TokOpenBrace:
+			depth++
+		case TokCloseBrace:
+			if depth == 0 {
+				return
+			}
+
+			depth--
+			if depth == 0 {
+				p.nextToken()
+				return
+			}
+		}
+
+		p.nextToken()
+	}
+}
+
+// Skip to the end of a broken top level declaration: past the next `;`
+// or block outside of any braces, or a stray `}`.
+func (p *Parser) syncTopLevel(start int) {
+	// The declaration may have been abandoned part way into a body, in
+	// which case the braces already consumed have to be closed too.
+	depth := 0
+	for i := start; i < p.tokIdx; i++ {
+		switch p.tokenAt(i).Kind {
+		case TokOpenBrace:
+			depth++
+		case TokCloseBrace:
+			depth--
+		}
+	}
+
+	if depth < 0 {
+		depth = 0
+	}
+
+	for {
+		switch p.token().Kind {
+		case TokEof:
+			return
+		case TokSemicolon:
+			if depth == 0 {
+				p.nextToken()
+				return
+			}
+		case TokOpenBrace:
+			depth++
+		case TokCloseBrace:
+			if depth <= 1 {
+				p.nextToken()
+				return
+			}
+
+			depth--
+		}
+
+		p.nextToken()
+	}
 }
 
 func (p *Parser) accept(t TokenType, str string) (*Token, bool) {
 	var tok Token
 
-	if p.token().kind == t {
-		if str == "" || str == p.token().value {
+	if p.token().Kind == t {
+		if str == "" || str == p.token().Value {
 			tok = p.token()
 
 			// Get next token if we've matched
-			if _, err := p.nextToken(); err != nil {
-				// TODO: handle this
-				panic(err)
-			}
+			p.nextToken()
 
 			return &tok, true
 
@@ -128,7 +351,7 @@ func (p *Parser) expectOneOf(t ...TokenType) (TokenType, Token, error) {
 	tok := p.token()
 
 	for _, tt := range t {
-		if p.token().kind == tt {
+		if p.token().Kind == tt {
 			p.nextToken()
 			return tt, tok, nil
 		}
@@ -140,7 +363,7 @@ func (p *Parser) expectOneOf(t ...TokenType) (TokenType, Token, error) {
 		types[i] = fmt.Sprintf("%s", tt)
 	}
 
-	return tkError, (&tok).Error(), NewParseError(p.token(),
+	return TokError, (&tok).Error(), NewParseError(p.token(),
 		fmt.Sprintf("Expected one of: %s", strings.Join(types, ", ")))
 }
 
@@ -148,43 +371,56 @@ func (p *Parser) expectType(t TokenType) (*Token, error) {
 	return p.expect(t, "")
 }
 
-func (p *Parser) nextToken() (Token, error) {
+// Tokens which fail to lex are skipped over, the lexer keeps track of
+// the errors.
+func (p *Parser) nextToken() Token {
 	p.tokIdx += 1
 
 	if p.tokIdx < len(p.tokens) {
-		return p.tokens[p.tokIdx], nil
+		return p.tokens[p.tokIdx]
 	}
 
 	tok, err := p.lex.NextToken()
-	if err != nil {
-		return tok, err
+	for err != nil {
+		tok, err = p.lex.NextToken()
 	}
 
 	p.tokens = append(p.tokens, tok)
 
-	return tok, nil
+	return tok
 }
 
 func (p *Parser) parseBlock() (*Node, error) {
-	if _, err := p.expectType(tkOpenBrace); err != nil {
+	start := p.tokIdx
+
+	if _, err := p.expectType(TokOpenBrace); err != nil {
 		return nil, err
 	}
 
 	block := BlockNode{}
 
-	for p.token().kind != tkCloseBrace {
+	for p.token().Kind != TokCloseBrace && p.token().Kind != TokEof {
+		stmtStart := p.tokIdx
+
 		stmt, err := p.parseStatement()
 		if err != nil {
-			return nil, err
+			p.errors = append(p.errors, err)
+			p.syncStatement()
+
+			block.Nodes = append(block.Nodes,
+				BadNode{p.spanFrom(stmtStart)})
+			continue
 		}
 
 		block.Nodes = append(block.Nodes, *stmt)
 	}
 
-	if _, err := p.expectType(tkCloseBrace); err != nil {
+	if _, err := p.expectType(TokCloseBrace); err != nil {
 		return nil, err
 	}
 
+	block.Span = p.spanFrom(start)
+
 	var node Node = block
 	return &node, nil
 }
@@ -192,251 +428,354 @@ func (p *Parser) parseBlock() (*Node, error) {
 func (p *Parser) parseConstant() (*Node, error) {
 	var node Node
 
-	kind, tok, err := p.expectOneOf(tkNumber, tkCharacter, tkString)
+	kind, tok, err := p.expectOneOf(TokNumber, TokCharacter, TokString)
 
 	if err != nil {
 		return nil, err
 	}
 
 	switch kind {
-	case tkNumber:
-		num, err := strconv.Atoi(tok.value)
+	case TokNumber:
+		num, err := p.parseInteger(tok)
 		if err != nil {
-			return nil, NewParseError(p.token(), "invalid integer literal")
+			return nil, err
 		}
 
-		node = IntegerNode{num}
+		node = num
 		return &node, err
-	case tkCharacter:
-		node = CharacterNode{tok.value}
+	case TokCharacter:
+		char, err := p.parseCharacter(tok)
+		if err != nil {
+			return nil, err
+		}
+
+		node = char
 		return &node, err
-	case tkString:
-		node = StringNode{tok.value}
+	case TokString:
+		node = StringNode{Span: tok.span(), Value: tok.Value}
 		return &node, err
 	default:
 		return nil, err
 	}
-
-	return nil, nil
 }
 
-func (p *Parser) parseSubExpression() (*Node, error) {
-	unNode := UnaryNode{Oper: ""}
-
-	// Unary prefix operator
-	if tok, ok := p.acceptType(tkOperator); ok {
-		// *, &, -, !, ++, --, and ~.
-		switch tok.value {
-		case "*", "&", "-", "!", "++", "--", "~":
-			unNode = UnaryNode{Oper: tok.value, Postfix: false}
-		default:
-			return nil, NewParseError(p.token(), "invalid unary op")
+// A leading 0 makes an integer literal octal, as in C. An octal literal
+// gives the bits of a word, so it's range checked as unsigned: on the
+// PDP-11, 0177777 is -1.
+func (p *Parser) parseInteger(tok Token) (IntegerNode, error) {
+	num := IntegerNode{Span: tok.span(), Radix: 10}
+
+	if len(tok.Value) > 1 && tok.Value[0] == '0' {
+		num.Radix = 8
+	}
+
+	var val int64
+	var err error
+
+	if num.Radix == 8 {
+		var bits uint64
+		bits, err = strconv.ParseUint(tok.Value, 8, p.Target.WordBits())
+		val = int64(bits)
+	} else {
+		val, err = strconv.ParseInt(tok.Value, 10, p.Target.WordBits())
+	}
+
+	if err != nil {
+		if numErr, ok := err.(*strconv.NumError); ok &&
+			numErr.Err == strconv.ErrRange {
+			return num, NewParseError(tok, fmt.Sprintf(
+				"integer literal %s overflows %d bit word",
+				tok.Value, p.Target.WordBits()))
 		}
+
+		return num, NewParseError(tok,
+			fmt.Sprintf("invalid integer literal: %s", tok.Value))
 	}
 
-	expr, err := p.parsePrimary()
+	num.Value = val
+	return num, nil
+}
+
+func (p *Parser) parseCharacter(tok Token) (CharacterNode, error) {
+	char := CharacterNode{Span: tok.span(), Text: tok.Value}
+
+	val, err := p.Target.PackChars(unescape(tok.Value))
 	if err != nil {
-		return nil, err
+		return char, NewParseError(tok, fmt.Sprintf(
+			"character constant '%s': %v", tok.Value, err))
+	}
+
+	char.Value = val
+	return char, nil
+}
+
+// An expression which must be evaluated at compile time.
+func (p *Parser) parseConstExpr() (Node, int64, error) {
+	expr, err := p.parseExpression()
+	if err != nil {
+		return nil, 0, err
 	}
 
-	// TODO: this logic is ugly.
-	if unNode.Oper != "" {
-		unNode.Node = *expr
-		*expr = unNode
+	val, err := p.Target.Eval(*expr)
+	if err != nil {
+		return nil, 0, err
 	}
 
-	if p.token().kind == tkOperator {
-		switch p.token().value {
-		case "++", "--": // Unary postfix operator
-			unNode = UnaryNode{Oper: p.token().value,
-				Node: *expr, Postfix: true}
-			*expr = unNode
+	return *expr, val, nil
+}
 
-			p.nextToken()
-		}
+// '[' constant expression ']', the size of a vector
+func (p *Parser) parseVectorSize() (Node, int, error) {
+	if _, err := p.expectType(TokOpenBracket); err != nil {
+		return nil, 0, err
+	}
+
+	expr, size, err := p.parseConstExpr()
+	if err != nil {
+		return nil, 0, err
 	}
 
-	return expr, nil
+	if size < 0 {
+		return nil, 0, NewSemanticError(expr,
+			fmt.Sprintf("negative vector size %d", size))
+	}
+
+	if _, err := p.expectType(TokCloseBracket); err != nil {
+		return nil, 0, err
+	}
+
+	return expr, int(size), nil
+}
+
+// Prefix unary operators bind less tightly than the postfix operators
+// handled in parsePrimary, so `*p++` is `*(p++)`.
+func (p *Parser) parseSubExpression() (*Node, error) {
+	start := p.tokIdx
+
+	tok, ok := p.acceptType(TokOperator)
+	if !ok {
+		return p.parsePrimary()
+	}
+
+	// *, &, -, !, ++, --, and ~.
+	switch tok.Value {
+	case "*", "&", "-", "!", "++", "--", "~":
+	default:
+		return nil, NewParseError(*tok, "invalid unary op")
+	}
+
+	expr, err := p.parseSubExpression()
+	if err != nil {
+		return nil, err
+	}
+
+	var node Node = UnaryNode{Span: p.spanFrom(start), Oper: tok.Value,
+		Node: *expr, Postfix: false}
+	return &node, nil
 }
 
 func (p *Parser) parseExpression() (*Node, error) {
+	return p.parseBinary(0)
+}
+
+// Precedence climbing: parse an expression made up of operators which
+// bind at least as tightly as minPrec, using the table in token.go.
+func (p *Parser) parseBinary(minPrec int) (*Node, error) {
 	node, err := p.parseSubExpression()
 	if err != nil {
 		return nil, err
 	}
 
-	if tok, ok := p.acceptType(tkOperator); ok {
-		rhs, err := p.parseExpression()
-		if err != nil {
-			return nil, err
+	for {
+		tok := p.token()
+
+		if tok.Kind == TokTernary {
+			tok.Value = "?"
+		} else if tok.Kind != TokOperator || !IsBinaryOp(tok.Value) {
+			return node, nil
 		}
 
-		var bin BinaryNode
+		prec, bind := OperatorPrecedence(tok.Value)
+		if prec < minPrec {
+			return node, nil
+		}
+
+		p.nextToken()
 
-		// Resolve precedence for multiple operators in expression
-		// TODO: currently ignores LTR, RTL binding
-		if rbin, ok := (*rhs).(BinaryNode); ok {
-			lproc, _ := OperatorPrecedence(tok.value)
-			rproc, _ := OperatorPrecedence(rbin.Oper)
+		// Operators binding left to right only take tighter binding
+		// operators on their right hand side.
+		rhsPrec := prec
+		if bind == opLR {
+			rhsPrec += 1
+		}
 
-			if lproc > rproc {
-				left := BinaryNode{Left: *node, Oper: tok.value,
-					Right: rbin.Left}
-				bin = BinaryNode{Left: left, Oper: rbin.Oper,
-					Right: rbin.Right}
+		if tok.Kind == TokTernary {
+			ter := TernaryNode{Cond: *node}
+
+			// Anything at all can sit between the '?' and ':'
+			if body, err := p.parseExpression(); err != nil {
+				return nil, err
 			} else {
-				bin = BinaryNode{Left: *node, Oper: tok.value,
-					Right: rbin}
+				ter.TrueBody = *body
 			}
 
-		} else {
-			bin = BinaryNode{Left: *node,
-				Oper: tok.value, Right: *rhs}
-		}
-
-		*node = bin
-	}
+			if _, err := p.expectType(TokColon); err != nil {
+				return nil, err
+			}
 
-	// Ternary operator
-	if _, ok := p.acceptType(tkTernary); ok {
-		ter := TernaryNode{Cond: *node}
+			if body, err := p.parseBinary(rhsPrec); err != nil {
+				return nil, err
+			} else {
+				ter.FalseBody = *body
+			}
 
-		if body, err := p.parseExpression(); err != nil {
-			return nil, err
-		} else {
-			ter.TrueBody = *body
+			ter.Span = Span{ter.Cond.Pos(), ter.FalseBody.End()}
+			*node = ter
+			continue
 		}
 
-		if _, err := p.expectType(tkColon); err != nil {
+		rhs, err := p.parseBinary(rhsPrec)
+		if err != nil {
 			return nil, err
 		}
 
-		if body, err := p.parseExpression(); err != nil {
-			return nil, err
-		} else {
-			ter.FalseBody = *body
+		*node = BinaryNode{
+			Span:  Span{(*node).Pos(), (*rhs).End()},
+			Left:  *node,
+			Oper:  tok.Value,
+			Right: *rhs,
 		}
-
-		*node = ter
 	}
-
-	return node, nil
 }
 
 func (p *Parser) parseExternVarDecl() (*Node, error) {
 	var err error
+	start := p.tokIdx
 
-	if _, err = p.expect(tkKeyword, "extrn"); err != nil {
+	if _, err = p.expect(TokKeyword, "extrn"); err != nil {
 		return nil, err
 	}
 
 	varNode := ExternVarDeclNode{}
 
-	if varNode.names, err = p.parseVariableList(); err != nil {
+	if varNode.Names, err = p.parseVariableList(); err != nil {
 		return nil, err
 	}
 
-	if _, err = p.expectType(tkSemicolon); err != nil {
+	if _, err = p.expectType(TokSemicolon); err != nil {
 		return nil, err
 	}
 
-	if len(varNode.names) <= 0 {
+	if len(varNode.Names) <= 0 {
 		return nil, NewParseError(p.token(),
 			"expected at least 1 variable in extrn"+
 				" declaration")
 	}
 
+	varNode.Span = p.spanFrom(start)
+
 	var node Node = varNode
 	return &node, nil
 }
 
+// name ('[' size? ']')? (ival (',' ival)*)? ';'
+//
+// where an ival is a constant or the name of another global, whose
+// address is stored.
 func (p *Parser) parseExternalVariableInit() (*Node, error) {
 	var err error
+	start := p.tokIdx
 
-	ident, err := p.expectType(tkIdent)
+	ident, err := p.expectType(TokIdent)
 
 	if err != nil {
 		return nil, err
 	}
 
-	if _, ok := p.acceptType(tkOpenBracket); ok {
-		init := ExternVecInitNode{Name: ident.value}
+	if p.token().Kind == TokOpenBracket {
+		init := ExternVecInitNode{Name: ident.Value}
 
-		size, err := p.expectType(tkNumber)
-		if err != nil {
-			return nil, err
+		if p.peekToken().Kind == TokCloseBracket {
+			// Size is taken from the number of values
+			p.nextToken()
+			p.nextToken()
+			init.InferSize = true
+		} else {
+			init.SizeExpr, init.Size, err = p.parseVectorSize()
+			if err != nil {
+				return nil, err
+			}
 		}
-		if _, err := p.expectType(tkCloseBracket); err != nil {
+
+		if init.Values, err = p.parseInitialValues(); err != nil {
 			return nil, err
 		}
 
-		// TODO: Assert declared size == actual size
-
-		init.Size, err = strconv.Atoi(size.value)
-
-		if err != nil {
-			return nil, NewParseError(p.token(),
-				"Bad integer literal")
+		if init.InferSize && len(init.Values) > 0 {
+			init.Size = len(init.Values) - 1
 		}
 
-		for {
-			if constant, err := p.parseConstant(); err != nil {
-				return nil, err
-			} else {
-				init.Values = append(init.Values, *constant)
-			}
-
-			if _, ok := p.acceptType(tkComma); !ok {
-				break
-			}
-		}
+		init.Span = p.spanFrom(start)
 
 		var node Node = init
-		if _, err = p.expectType(tkSemicolon); err != nil {
-			return nil, err
-		}
 		return &node, nil
 	} else {
-		init := ExternVarInitNode{Name: ident.value}
+		init := ExternVarInitNode{Name: ident.Value}
 
-		constant, err := p.parseConstant()
-		if err != nil {
-			if _, err = p.expectType(tkSemicolon); err == nil {
-				// Empty declarations are zero filled
-				init.Value = IntegerNode{0}
-				var node Node = init
-				return &node, nil
-			}
-		} else {
-			init.Value = *constant
-		}
-
-		if err != nil {
+		if init.Values, err = p.parseInitialValues(); err != nil {
 			return nil, err
 		}
 
+		init.Span = p.spanFrom(start)
+
 		var node Node = init
-		if _, err = p.expectType(tkSemicolon); err != nil {
+		return &node, nil
+	}
+}
+
+// (ival (',' ival)*)? ';'
+func (p *Parser) parseInitialValues() ([]Node, error) {
+	var values []Node
+
+	if _, ok := p.acceptType(TokSemicolon); ok {
+		return values, nil
+	}
+
+	for {
+		if tok, ok := p.acceptType(TokIdent); ok {
+			values = append(values, IdentNode{Span: tok.span(),
+				Value: tok.Value})
+		} else if constant, err := p.parseConstant(); err != nil {
 			return nil, err
+		} else {
+			values = append(values, *constant)
+		}
+
+		if _, ok := p.acceptType(TokComma); !ok {
+			break
 		}
-		return &node, nil
 	}
 
-	return nil, nil
+	if _, err := p.expectType(TokSemicolon); err != nil {
+		return nil, err
+	}
+
+	return values, nil
 }
 
 func (p *Parser) parseFuncDeclaration() (*Node, error) {
 	var err error
+	start := p.tokIdx
 
-	id, err := p.expectType(tkIdent)
+	id, err := p.expectType(TokIdent)
 
 	if err != nil {
 		return nil, err
 	}
 
-	fnNode := FunctionNode{Name: id.value}
+	fnNode := FunctionNode{Name: id.Value}
 
-	if _, err = p.expectType(tkOpenParen); err != nil {
+	if _, err = p.expectType(TokOpenParen); err != nil {
 		return nil, err
 	}
 
@@ -444,7 +783,7 @@ func (p *Parser) parseFuncDeclaration() (*Node, error) {
 		return nil, err
 	}
 
-	if _, err = p.expectType(tkCloseParen); err != nil {
+	if _, err = p.expectType(TokCloseParen); err != nil {
 		return nil, err
 	}
 
@@ -455,28 +794,31 @@ func (p *Parser) parseFuncDeclaration() (*Node, error) {
 	}
 
 	fnNode.Body = *stmt
+	fnNode.Span = p.spanFrom(start)
 
 	var node Node = fnNode
 	return &node, err
 }
 
 func (p *Parser) parseIdent() (*Node, error) {
-	tok, err := p.expectType(tkIdent)
+	tok, err := p.expectType(TokIdent)
 
 	if err != nil {
 		return nil, err
 	}
 
-	var node Node = IdentNode{tok.value}
+	var node Node = IdentNode{Span: tok.span(), Value: tok.Value}
 	return &node, nil
 }
 
 func (p *Parser) parseIf() (*Node, error) {
-	if _, err := p.expect(tkKeyword, "if"); err != nil {
+	start := p.tokIdx
+
+	if _, err := p.expect(TokKeyword, "if"); err != nil {
 		return nil, err
 	}
 
-	if _, err := p.expectType(tkOpenParen); err != nil {
+	if _, err := p.expectType(TokOpenParen); err != nil {
 		return nil, err
 	}
 
@@ -485,7 +827,7 @@ func (p *Parser) parseIf() (*Node, error) {
 		return nil, err
 	}
 
-	if _, err := p.expectType(tkCloseParen); err != nil {
+	if _, err := p.expectType(TokCloseParen); err != nil {
 		return nil, err
 	}
 
@@ -497,7 +839,7 @@ func (p *Parser) parseIf() (*Node, error) {
 	var elseBody Node
 	var hasElse = false
 
-	if _, ok := p.accept(tkKeyword, "else"); ok {
+	if _, ok := p.accept(TokKeyword, "else"); ok {
 		hasElse = true
 		els, err := p.parseStatement()
 		if err != nil {
@@ -507,14 +849,16 @@ func (p *Parser) parseIf() (*Node, error) {
 		elseBody = *els
 	}
 
-	var node Node = IfNode{Cond: *cond, Body: *trueBody, HasElse: hasElse,
-		ElseBody: elseBody}
+	var node Node = IfNode{Span: p.spanFrom(start), Cond: *cond,
+		Body: *trueBody, HasElse: hasElse, ElseBody: elseBody}
 	return &node, nil
 
 }
 
 func (p *Parser) parseParen() (*Node, error) {
-	if _, err := p.expectType(tkOpenParen); err != nil {
+	start := p.tokIdx
+
+	if _, err := p.expectType(TokOpenParen); err != nil {
 		return nil, err
 	}
 
@@ -523,66 +867,95 @@ func (p *Parser) parseParen() (*Node, error) {
 		return nil, err
 	}
 
-	if _, err := p.expectType(tkCloseParen); err != nil {
+	if _, err := p.expectType(TokCloseParen); err != nil {
 		return nil, err
 	}
 
-	var node Node = ParenNode{*inner}
+	var node Node = ParenNode{Span: p.spanFrom(start), Node: *inner}
 	return &node, nil
 }
 
-// TODO: unfinished, untested
+// primary ('[' expr ']' | '(' args ')' | '++' | '--')*
 func (p *Parser) parsePrimary() (node *Node, err error) {
+	start := p.tokIdx
+
+	// Once a token has been taken, its error is the one to report: a
+	// literal out of range, say, rather than what follows it.
 	if node, err = p.parseParen(); err == nil {
+	} else if p.tokIdx != start {
+		return nil, err
 	} else if node, err = p.parseConstant(); err == nil {
+	} else if p.tokIdx != start {
+		return nil, err
 	} else if node, err = p.parseIdent(); err == nil {
 	} else {
 		return nil, NewParseError(p.token(), "expected primary expression")
 	}
 
-	// Array access
-	if _, ok := p.acceptType(tkOpenBracket); ok {
-		array := *node
-		index, err := p.parseExpression()
+	for {
+		switch tok := p.token(); {
+		case tok.Kind == TokOpenBracket:
+			p.nextToken()
 
-		if err != nil {
-			return nil, err
-		}
-		if _, err := p.expectType(tkCloseBracket); err != nil {
-			return nil, err
-		}
+			index, err := p.parseExpression()
+			if err != nil {
+				return nil, err
+			}
+			if _, err := p.expectType(TokCloseBracket); err != nil {
+				return nil, err
+			}
 
-		*node = ArrayAccessNode{Array: array, Index: *index}
-		return node, nil
-	}
+			*node = ArrayAccessNode{Span: p.spanFrom(start), Array: *node,
+				Index: *index}
 
-	// Function call
-	if _, ok := p.acceptType(tkOpenParen); ok {
-		args := make([]Node, 0, 10)
+		case tok.Kind == TokOpenParen:
+			p.nextToken()
 
-		if p.token().kind != tkCloseParen {
-			for {
-				arg, err := p.parseExpression()
+			args, err := p.parseArguments()
+			if err != nil {
+				return nil, err
+			}
 
-				if err != nil {
-					return nil, err
-				}
-				args = append(args, *arg)
+			*node = FunctionCallNode{Span: p.spanFrom(start),
+				Callable: *node, Args: args}
 
-				if _, ok := p.acceptType(tkComma); !ok {
-					break
-				}
-			}
+		case tok.Kind == TokOperator && (tok.Value == "++" || tok.Value == "--"):
+			p.nextToken()
+
+			*node = UnaryNode{Span: p.spanFrom(start), Oper: tok.Value,
+				Node: *node, Postfix: true}
+
+		default:
+			return node, nil
 		}
+	}
+}
+
+// (expr (',' expr)*)? ')', the opening paren having been consumed
+func (p *Parser) parseArguments() ([]Node, error) {
+	args := make([]Node, 0, 10)
+
+	if _, ok := p.acceptType(TokCloseParen); ok {
+		return args, nil
+	}
 
-		if _, err := p.expectType(tkCloseParen); err != nil {
+	for {
+		arg, err := p.parseExpression()
+		if err != nil {
 			return nil, err
 		}
-		*node = FunctionCallNode{Callable: *node, Args: args}
-		return node, nil
+		args = append(args, *arg)
+
+		if _, ok := p.acceptType(TokComma); !ok {
+			break
+		}
 	}
 
-	return node, nil
+	if _, err := p.expectType(TokCloseParen); err != nil {
+		return nil, err
+	}
+
+	return args, nil
 }
 
 func (p *Parser) parseStatement() (node *Node, err error) {
@@ -624,62 +997,72 @@ func (p *Parser) parseStatement() (node *Node, err error) {
 		return node, nil
 	}
 
-	if _, ok := p.acceptType(tkSemicolon); ok {
-		var null Node = NullNode{}
+	if node, err := p.parseCaseLabel(); err != nil && p.tokIdx != pos {
+		return nil, err
+	} else if err == nil {
+		return node, nil
+	}
+
+	if _, ok := p.acceptType(TokSemicolon); ok {
+		var null Node = NullNode{p.spanFrom(pos)}
 		return &null, nil
 	}
 
-	if _, ok := p.accept(tkKeyword, "break"); ok {
-		if _, err := p.expectType(tkSemicolon); err != nil {
+	if _, ok := p.accept(TokKeyword, "break"); ok {
+		if _, err := p.expectType(TokSemicolon); err != nil {
 			return nil, err
 		}
 
-		var brk Node = BreakNode{}
+		var brk Node = BreakNode{p.spanFrom(pos)}
 		return &brk, nil
 	}
 
-	if _, ok := p.accept(tkKeyword, "return"); ok {
+	if _, ok := p.accept(TokKeyword, "return"); ok {
 		var retNode ReturnNode
-		if _, ok := p.acceptType(tkSemicolon); ok {
-			retNode.Node = NullNode{}
+		if tok, ok := p.acceptType(TokSemicolon); ok {
+			retNode.Node = NullNode{Span{tok.Start, tok.Start}}
 		} else {
 			node, err := p.parseExpression()
 			if err != nil {
 				return nil, err
 			}
 
-			if _, err := p.expectType(tkSemicolon); err != nil {
+			if _, err := p.expectType(TokSemicolon); err != nil {
 				return nil, err
 			}
 			retNode.Node = *node
 		}
 
+		retNode.Span = p.spanFrom(pos)
+
 		var node Node = retNode
 		return &node, nil
 	}
 
-	if _, ok := p.accept(tkKeyword, "goto"); ok {
-		var tok *Token = nil
-
-		if tok, err = p.expectType(tkIdent); err != nil {
+	// The target is an rvalue: a label, or anything holding one.
+	if _, ok := p.accept(TokKeyword, "goto"); ok {
+		target, err := p.parseExpression()
+		if err != nil {
 			return nil, err
 		}
 
-		var gt Node = GotoNode{Label: tok.value}
-
-		if _, err := p.expectType(tkSemicolon); err != nil {
+		if _, err := p.expectType(TokSemicolon); err != nil {
 			return nil, err
 		}
 
+		var gt Node = GotoNode{Span: p.spanFrom(pos), Target: *target}
+
 		return &gt, nil
 	}
 
-	if tok, ok := p.acceptType(tkIdent); ok {
-		if _, ok := p.acceptType(tkColon); ok {
-			var node Node = LabelNode{tok.value}
+	if tok, ok := p.acceptType(TokIdent); ok {
+		if _, ok := p.acceptType(TokColon); ok {
+			var node Node = LabelNode{Span: p.spanFrom(pos),
+				Name: tok.Value}
 			return &node, nil
-		} else if _, ok := p.acceptType(tkSemicolon); ok {
-			var node Node = StatementNode{IdentNode{tok.value}}
+		} else if _, ok := p.acceptType(TokSemicolon); ok {
+			var node Node = StatementNode{Span: p.spanFrom(pos),
+				Expr: IdentNode{Span: tok.span(), Value: tok.Value}}
 			return &node, nil
 		}
 
@@ -690,120 +1073,71 @@ func (p *Parser) parseStatement() (node *Node, err error) {
 	if node, err := p.parseExpression(); err != nil && p.tokIdx != pos {
 		return nil, err
 	} else if err == nil {
-		if _, err := p.expectType(tkSemicolon); err != nil {
+		if _, err := p.expectType(TokSemicolon); err != nil {
 			return nil, err
 		}
-		*node = StatementNode{Expr: *node}
+		*node = StatementNode{Span: p.spanFrom(pos), Expr: *node}
 		return node, nil
 	}
 
 	return nil, NewParseError(p.tokenAt(pos), "expected statement")
 }
 
-// TODO: this logic is all over the place. refactor.
+// 'switch' '(' expr ')' statement
+//
+// As in C, the body can be any statement, with the case labels
+// belonging to the switch found anywhere inside it.
 func (p *Parser) parseSwitch() (*Node, error) {
-	var switchNode SwitchNode
+	start := p.tokIdx
 
-	if _, err := p.expect(tkKeyword, "switch"); err != nil {
+	if _, err := p.expect(TokKeyword, "switch"); err != nil {
 		return nil, err
 	}
 
-	if _, err := p.expectType(tkOpenParen); err != nil {
+	if _, err := p.expectType(TokOpenParen); err != nil {
 		return nil, err
 	}
 
-	if cond, err := p.parseExpression(); err != nil {
+	cond, err := p.parseExpression()
+	if err != nil {
 		return nil, err
-	} else {
-		switchNode.Cond = *cond
 	}
 
-	if _, err := p.expectType(tkCloseParen); err != nil {
+	if _, err := p.expectType(TokCloseParen); err != nil {
 		return nil, err
 	}
 
-	// I know, it can technically be any statement, but I'll leave it
-	// as a block for now.
-	if _, err := p.expectType(tkOpenBrace); err != nil {
+	body, err := p.parseStatement()
+	if err != nil {
 		return nil, err
 	}
 
-	for {
-		if _, ok := p.acceptType(tkCloseBrace); ok {
-			break
-		}
-
-		if _, ok := p.accept(tkKeyword, "case"); ok {
-			var c CaseNode
-
-			if cond, err := p.parseConstant(); err != nil {
-				return nil, err
-			} else {
-				c = CaseNode{Cond: *cond}
-			}
-
-			if _, err := p.expectType(tkColon); err != nil {
-				return nil, err
-			}
-
-			for {
-				if _, ok := p.accept(tkKeyword, "case"); ok {
-					p.tokIdx -= 1
-					break
-				} else if _, ok := p.accept(tkKeyword, "default"); ok {
-					p.tokIdx -= 1
-					break
-				} else if _, ok := p.acceptType(tkCloseBrace); ok {
-					p.tokIdx -= 1
-					break
-				}
-
-				if stmt, err := p.parseStatement(); err != nil {
-					return nil, err
-				} else {
-					c.Statements = append(c.Statements, *stmt)
-				}
-			}
-
-			switchNode.Cases = append(switchNode.Cases, c)
-
-		} else if _, ok := p.accept(tkKeyword, "default"); ok {
-			if _, err := p.expectType(tkColon); err != nil {
-				return nil, err
-			}
+	var node Node = SwitchNode{Span: p.spanFrom(start), Cond: *cond,
+		Body: *body}
+	return &node, nil
+}
 
-			if switchNode.DefaultCase != nil {
-				return nil, NewParseError(p.token(),
-					"Multiple 'default' cases")
-			}
+// 'case' constant expression ':' | 'default' ':'
+func (p *Parser) parseCaseLabel() (*Node, error) {
+	start := p.tokIdx
+	var label CaseLabelNode
+	var err error
 
-			for {
-				if _, ok := p.accept(tkKeyword, "case"); ok {
-					p.tokIdx -= 1
-					break
-				} else if _, ok := p.accept(tkKeyword, "default"); ok {
-					p.tokIdx -= 1
-					break
-				} else if _, ok := p.acceptType(tkCloseBrace); ok {
-					p.tokIdx -= 1
-					break
-				}
-
-				if stmt, err := p.parseStatement(); err != nil {
-					return nil, err
-				} else {
-					switchNode.DefaultCase =
-						append(switchNode.DefaultCase, *stmt)
-				}
-			}
+	if _, ok := p.accept(TokKeyword, "default"); ok {
+		label.Default = true
+	} else if _, err = p.expect(TokKeyword, "case"); err != nil {
+		return nil, err
+	} else if label.Cond, label.Value, err = p.parseConstExpr(); err != nil {
+		return nil, err
+	}
 
-		} else {
-			return nil, NewParseError(p.token(),
-				"expected 'case' or 'default'")
-		}
+	if _, err := p.expectType(TokColon); err != nil {
+		return nil, err
 	}
 
-	var node Node = switchNode
+	label.Span = p.spanFrom(start)
+
+	var node Node = label
 	return &node, nil
 }
 
@@ -834,48 +1168,39 @@ func (p *Parser) parseTopLevel() (node *Node, err error) {
 
 func (p *Parser) parseVarDecl() (*Node, error) {
 	var err error
+	start := p.tokIdx
 
-	if _, err = p.expect(tkKeyword, "auto"); err != nil {
+	if _, err = p.expect(TokKeyword, "auto"); err != nil {
 		return nil, err
 	}
 
 	varNode := VarDeclNode{}
 
 	for {
-		ident, err := p.expectType(tkIdent)
+		ident, err := p.expectType(TokIdent)
 		if err != nil {
 			return nil, err
 		}
 
-		if _, ok := p.acceptType(tkOpenBracket); ok {
-
-			if num, err := p.expectType(tkNumber); err != nil {
-				return nil, err
-			} else {
-				size, err := strconv.Atoi(num.value)
-
-				if err != nil {
-					return nil, NewParseError(p.token(), "invalid integer literal")
-				}
+		decl := VarDecl{Name: ident.Value}
 
-				varNode.Vars = append(varNode.Vars,
-					VarDecl{ident.value, true, size})
-			}
+		if p.token().Kind == TokOpenBracket {
+			decl.VecDecl = true
 
-			if _, err := p.expectType(tkCloseBracket); err != nil {
+			decl.SizeExpr, decl.Size, err = p.parseVectorSize()
+			if err != nil {
 				return nil, err
 			}
-		} else {
-			varNode.Vars = append(varNode.Vars,
-				VarDecl{ident.value, false, 0})
 		}
 
-		if _, ok := p.acceptType(tkComma); !ok {
+		varNode.Vars = append(varNode.Vars, decl)
+
+		if _, ok := p.acceptType(TokComma); !ok {
 			break
 		}
 	}
 
-	if _, err = p.expectType(tkSemicolon); err != nil {
+	if _, err = p.expectType(TokSemicolon); err != nil {
 		return nil, err
 	}
 
@@ -884,6 +1209,8 @@ func (p *Parser) parseVarDecl() (*Node, error) {
 			"expected at least 1 variable in auto declaration")
 	}
 
+	varNode.Span = p.spanFrom(start)
+
 	var node Node = varNode
 	return &node, nil
 }
@@ -893,15 +1220,15 @@ func (p *Parser) parseVariableList() ([]string, error) {
 	var err error
 	var vars []string = nil
 
-	id, ok := p.acceptType(tkIdent)
+	id, ok := p.acceptType(TokIdent)
 	for id != nil && ok {
-		vars = append(vars, id.value)
+		vars = append(vars, id.Value)
 
-		if _, ok := p.acceptType(tkComma); !ok {
+		if _, ok := p.acceptType(TokComma); !ok {
 			break
 		}
 
-		if id, err = p.expectType(tkIdent); err != nil {
+		if id, err = p.expectType(TokIdent); err != nil {
 			return nil, err
 		}
 	}
@@ -910,11 +1237,13 @@ func (p *Parser) parseVariableList() ([]string, error) {
 }
 
 func (p *Parser) parseWhile() (*Node, error) {
-	if _, err := p.expect(tkKeyword, "while"); err != nil {
+	start := p.tokIdx
+
+	if _, err := p.expect(TokKeyword, "while"); err != nil {
 		return nil, err
 	}
 
-	if _, err := p.expectType(tkOpenParen); err != nil {
+	if _, err := p.expectType(TokOpenParen); err != nil {
 		return nil, err
 	}
 
@@ -923,7 +1252,7 @@ func (p *Parser) parseWhile() (*Node, error) {
 		return nil, err
 	}
 
-	if _, err := p.expectType(tkCloseParen); err != nil {
+	if _, err := p.expectType(TokCloseParen); err != nil {
 		return nil, err
 	}
 
@@ -932,9 +1261,28 @@ func (p *Parser) parseWhile() (*Node, error) {
 		return nil, err
 	}
 
-	var node Node = WhileNode{Cond: *cond, Body: *body}
+	var node Node = WhileNode{Span: p.spanFrom(start), Cond: *cond,
+		Body: *body}
 	return &node, nil
 }
 
+// The token after the current one, without moving past it.
+func (p *Parser) peekToken() Token {
+	tok := p.nextToken()
+	p.tokIdx -= 1
+
+	return tok
+}
+
 func (p *Parser) tokenAt(idx int) Token { return p.tokens[idx] }
 func (p *Parser) token() Token          { return p.tokenAt(p.tokIdx) }
+
+// Span covering the token at index start through the last token consumed.
+func (p *Parser) spanFrom(start int) Span {
+	if p.tokIdx <= start {
+		pos := p.tokenAt(start).Start
+		return Span{pos, pos}
+	}
+
+	return Span{p.tokenAt(start).Start, p.tokenAt(p.tokIdx - 1).End}
+}
@@ -1,6 +1,8 @@
 package parse
 
 import (
+	"encoding/binary"
+	"fmt"
 	"strings"
 	"testing"
 )
@@ -8,19 +10,19 @@ import (
 func TestParserAccept(t *testing.T) {
 	parser := NewParser("name", strings.NewReader("1 abc"))
 
-	if tok, err := parser.accept(tkNumber, "2"); tok != nil {
+	if tok, err := parser.accept(TokNumber, "2"); tok != nil {
 		t.Errorf("Accept: value incorrect: %v, %v", tok, err)
 	}
 
-	if tok, err := parser.accept(tkNumber, "1"); tok == nil {
+	if tok, err := parser.accept(TokNumber, "1"); tok == nil {
 		t.Errorf("Accept: correct: %v", err)
 	}
 
-	if tok, err := parser.accept(tkNumber, "abc"); tok != nil {
+	if tok, err := parser.accept(TokNumber, "abc"); tok != nil {
 		t.Errorf("Accept: type incorrect: %v, %v", tok, err)
 	}
 
-	if tok, err := parser.accept(tkIdent, "abc"); tok == nil {
+	if tok, err := parser.accept(TokIdent, "abc"); tok == nil {
 		t.Errorf("Accept: next correct: %v", err)
 	}
 }
@@ -28,22 +30,22 @@ func TestParserAccept(t *testing.T) {
 func TestParserExpect(t *testing.T) {
 	parser := NewParser("name", strings.NewReader("1 2 type_incorrect 3"))
 
-	tok, err := parser.expect(tkNumber, "1")
+	tok, err := parser.expect(TokNumber, "1")
 	if tok == nil || err != nil {
 		t.Errorf("Expect: %v, %v", tok, err)
 	}
 
-	tok, err = parser.expect(tkNumber, "value_incorrect")
+	tok, err = parser.expect(TokNumber, "value_incorrect")
 	if tok != nil || err == nil {
 		t.Errorf("Expect value incorrect: %v", tok)
 	}
 
-	tok, err = parser.expect(tkNumber, "type_incorrect")
+	tok, err = parser.expect(TokNumber, "type_incorrect")
 	if tok != nil || err == nil {
 		t.Errorf("Expect type incorrect: %v", tok)
 	}
 
-	tok, err = parser.expectType(tkNumber)
+	tok, err = parser.expectType(TokNumber)
 	if tok == nil || err != nil {
 		t.Errorf("Expect type: %v", err)
 	}
@@ -145,13 +147,17 @@ func TestParsePrimary(t *testing.T) {
 	}
 
 	parser = NewParser("name", strings.NewReader(`
-(func)(1,(ab(c)),3)
+(func)(1,(ab(c)),3);
 ((abb(++a))[23])[ab(c(d[2]))]
 `))
 	if _, err := parser.parsePrimary(); err != nil {
 		t.Errorf("Complex func call: %v", err)
 	}
 
+	if _, err := parser.expectType(TokSemicolon); err != nil {
+		t.Errorf("Complex func call: %v", err)
+	}
+
 	if _, err := parser.parsePrimary(); err != nil {
 		t.Errorf("Complex array access: %v", err)
 	}
@@ -176,7 +182,7 @@ a++, a--,                     /* postfix ops */
 			t.Errorf("Expression unary: %v", *node)
 		}
 
-		if _, err := parser.expectType(tkComma); err != nil {
+		if _, err := parser.expectType(TokComma); err != nil {
 			t.Errorf("Error %v", err)
 		}
 
@@ -198,7 +204,7 @@ a=b+++-(--c)*4;
 		t.Errorf("Expression unary: %v", err)
 	}
 
-	if _, err := parser.expectType(tkSemicolon); err != nil {
+	if _, err := parser.expectType(TokSemicolon); err != nil {
 		t.Errorf("parse: %v", err)
 	}
 
@@ -206,7 +212,7 @@ a=b+++-(--c)*4;
 		t.Errorf("Complex expression: %v", err)
 	}
 
-	if _, err := parser.expectType(tkSemicolon); err != nil {
+	if _, err := parser.expectType(TokSemicolon); err != nil {
 		t.Errorf("parse: %v", err)
 	}
 
@@ -266,11 +272,64 @@ switch(1+1) {
 }
 `))
 
-	if _, err := parser.parseSwitch(); err != nil {
-		t.Errorf("Switch statement: %v", err)
+	node, err := parser.parseSwitch()
+	if err != nil {
+		t.Fatalf("Switch statement: %v", err)
+	}
+
+	sw := (*node).(SwitchNode)
+	if sw.Cond.String() != "1 + 1" {
+		t.Errorf("Switch condition: %v", sw.Cond)
 	}
 
-	// TODO: actually test this
+	var expect = []string{"case 2:", "case 3:", "default:"}
+	cases := SwitchCases(sw)
+
+	if len(cases) != len(expect) {
+		t.Fatalf("Expected %d cases, got %v", len(expect), cases)
+	}
+
+	for i, str := range expect {
+		if cases[i].String() != str {
+			t.Errorf("Expected %s, got %v", str, cases[i])
+		}
+	}
+
+	// The body can be any statement, with labels nested anywhere in it
+	parser = NewParser("", strings.NewReader(`
+switch (n % 2) while (n > 0) {
+  case 0: *to++ = *from++;
+  case 1: if (x) { default: y = 1; }
+  n =- 2;
+}`))
+
+	if node, err = parser.parseSwitch(); err != nil {
+		t.Fatalf("Switch statement: %v", err)
+	}
+
+	sw = (*node).(SwitchNode)
+	if _, ok := sw.Body.(WhileNode); !ok {
+		t.Errorf("Expected while body, got %T", sw.Body)
+	}
+
+	if cases = SwitchCases(sw); len(cases) != 3 || !cases[2].Default {
+		t.Errorf("Expected 3 cases ending in default, got %v", cases)
+	}
+
+	// Labels of nested switches belong to the nested switch
+	parser = NewParser("", strings.NewReader(`
+switch (a) {
+  case 1: switch (b) { case 1: case 2: ; }
+  case 2: ;
+}`))
+
+	if node, err = parser.parseSwitch(); err != nil {
+		t.Fatalf("Switch statement: %v", err)
+	}
+
+	if cases = SwitchCases((*node).(SwitchNode)); len(cases) != 2 {
+		t.Errorf("Expected 2 cases, got %v", cases)
+	}
 }
 
 func TestParseStatement(t *testing.T) {
@@ -363,7 +422,7 @@ switch(1+1) {
 	}
 
 	// eat leftover ':'
-	if tok, ok := parser.acceptType(tkColon); !ok {
+	if tok, ok := parser.acceptType(TokColon); !ok {
 		t.Errorf("Expected colon: %v", tok)
 	}
 
@@ -373,10 +432,9 @@ switch(1+1) {
 
 }
 
-// TODO: I'm only sort of sure about the correctness of these
 func TestParseOperatorPrecedence(t *testing.T) {
 	parser := NewParser("", strings.NewReader(`
-a=b+c---d /* (a = (b + (c-- - d))) */
+a=b+c---d /* (a = ((b + c--) - d)) */
 a+2*--a=b=c /* ((a + (2 * --a)) = (b = c)) */
 a=b=c+d=e
 `))
@@ -387,7 +445,7 @@ a=b=c+d=e
 	}
 
 	if str := (*node).(BinaryNode).StringWithPrecedence(); str !=
-		"(a = (b + (c-- - d)))" {
+		"(a = ((b + c--) - d))" {
 		t.Errorf("Bad precedence: %s", str)
 	}
 
@@ -464,3 +522,637 @@ func TestParse(t *testing.T) {
 	}
 
 }
+
+func TestParsePositions(t *testing.T) {
+	parser := NewParser("pos.b", strings.NewReader(`
+main() {
+  x = foo(1,
+          2);
+}`))
+
+	unit, err := parser.Parse()
+	if err != nil {
+		t.Fatalf("Parse: %v", err)
+	}
+
+	fn := unit.Funcs[0]
+	if pos := fn.Pos(); pos.Filename != "pos.b" || pos.Line != 2 ||
+		pos.Column != 1 {
+		t.Errorf("Function start: %v", pos)
+	}
+
+	if end := fn.End(); end.Line != 5 || end.Column != 2 {
+		t.Errorf("Function end: %v", end)
+	}
+
+	stmt := fn.Body.(BlockNode).Nodes[0].(StatementNode)
+	if pos, end := stmt.Pos(), stmt.End(); pos.Line != 3 ||
+		pos.Column != 3 || end.Line != 4 || end.Column != 14 {
+		t.Errorf("Statement span: %v - %v", pos, end)
+	}
+
+	call := stmt.Expr.(BinaryNode).Right.(FunctionCallNode)
+	if pos := call.Args[1].Pos(); pos.Line != 4 || pos.Column != 11 {
+		t.Errorf("Argument position: %v", pos)
+	}
+
+	err = NewSemanticError(call, "oops")
+	if !strings.HasPrefix(err.Error(), "pos.b:3:7: ") {
+		t.Errorf("Semantic error position: %v", err)
+	}
+}
+
+func TestParseAssignmentOps(t *testing.T) {
+	parser := NewParser("", strings.NewReader(`
+a =+ 1; a =<< b | c; a === b ^ c; a = b << 1 + 2; a = b & c | d;
+`))
+
+	var expect = []string{
+		"(a =+ 1)",
+		"(a =<< (b | c))",
+		"(a === (b ^ c))",
+		"(a = (b << (1 + 2)))",
+		"(a = ((b & c) | d))",
+	}
+
+	for _, str := range expect {
+		node, err := parser.parseStatement()
+		if err != nil {
+			t.Errorf("Assignment op: %v", err)
+			continue
+		}
+
+		bin := (*node).(StatementNode).Expr.(BinaryNode)
+		if got := bin.StringWithPrecedence(); got != str {
+			t.Errorf("Expected %s, got %s", str, got)
+		}
+	}
+}
+
+func TestParseInteger(t *testing.T) {
+	parser := NewParser("", strings.NewReader(`
+017 0 17 9223372036854775807 9223372036854775808 0x17`))
+
+	var expect = []struct {
+		value int64
+		radix int
+	}{{15, 8}, {0, 10}, {17, 10}, {9223372036854775807, 10}}
+
+	for _, e := range expect {
+		node, err := parser.parseConstant()
+		if err != nil {
+			t.Errorf("Integer %d: %v", e.value, err)
+			continue
+		}
+
+		num := (*node).(IntegerNode)
+		if num.Value != e.value || num.Radix != e.radix {
+			t.Errorf("Expected %d (base %d), got: %d (base %d)",
+				e.value, e.radix, num.Value, num.Radix)
+		}
+	}
+
+	if _, err := parser.parseConstant(); err == nil ||
+		!strings.Contains(err.Error(), "9223372036854775808 overflows") {
+		t.Errorf("Overflow: %v", err)
+	}
+
+	if _, err := parser.parseConstant(); err == nil {
+		t.Errorf("Hex literal accepted")
+	}
+
+	// Range checks follow the target word size. Octal literals are a
+	// word's bits, decimal ones its signed value.
+	parser = NewParser("", strings.NewReader(
+		`077777 0177777 0200000 32767 32768`))
+	parser.Target = Target{WordSize: 2}
+
+	if node, err := parser.parseConstant(); err != nil {
+		t.Errorf("16 bit max: %v", err)
+	} else if str := (*node).String(); str != "077777" {
+		t.Errorf("Octal string: %s", str)
+	}
+
+	if node, err := parser.parseConstant(); err != nil {
+		t.Errorf("16 bit octal -1: %v", err)
+	} else if str := (*node).String(); str != "0177777" {
+		t.Errorf("Octal string: %s", str)
+	} else if val, _ := parser.Target.Eval(*node); val != -1 {
+		t.Errorf("Expected 0177777 == -1, got %d", val)
+	}
+
+	if _, err := parser.parseConstant(); err == nil ||
+		!strings.Contains(err.Error(), "overflows 16 bit word") {
+		t.Errorf("16 bit octal overflow: %v", err)
+	}
+
+	if _, err := parser.parseConstant(); err != nil {
+		t.Errorf("16 bit decimal max: %v", err)
+	}
+
+	if _, err := parser.parseConstant(); err == nil ||
+		!strings.Contains(err.Error(), "overflows 16 bit word") {
+		t.Errorf("16 bit decimal overflow: %v", err)
+	}
+}
+
+// Literals out of range are reported as such wherever they appear, not
+// as a missing expression.
+func TestParseIntegerOverflowInExpr(t *testing.T) {
+	if _, err := ParseExpr("", "1 + (9223372036854775808)"); err == nil ||
+		!strings.Contains(err.Error(), "9223372036854775808 overflows") {
+		t.Errorf("Expression: %v", err)
+	}
+
+	if _, err := ParseExpr("", "017777777777777777777777"); err == nil ||
+		!strings.Contains(err.Error(), "overflows 64 bit word") {
+		t.Errorf("Octal: %v", err)
+	}
+
+	for _, src := range []string{
+		"f() { return (9223372036854775808); }",
+		"f(x) { switch (x) { case 9223372036854775808: ; } }",
+		"f() { auto v[9223372036854775808]; }",
+	} {
+		_, err := NewParser("", strings.NewReader(src)).Parse()
+
+		if err == nil || !strings.Contains(err.Error(), "overflows") {
+			t.Errorf("%s: %v", src, err)
+		}
+	}
+}
+
+func TestParseCharacter(t *testing.T) {
+	var tests = []struct {
+		src    string
+		little int64
+		big    int64
+	}{
+		{`'a'`, 'a', 'a'},
+		{`'ab'`, 'b'<<8 | 'a', 'a'<<8 | 'b'},
+		{`'*n*e'`, 4<<8 | '\n', '\n'<<8 | 4},
+		{`'*(**'`, '*'<<8 | '{', '{'<<8 | '*'},
+		{`''`, 0, 0},
+	}
+
+	for _, test := range tests {
+		little := NewParser("", strings.NewReader(test.src))
+		big := NewParser("", strings.NewReader(test.src))
+		big.Target.ByteOrder = binary.BigEndian
+
+		if node, err := little.parseConstant(); err != nil {
+			t.Errorf("%s: %v", test.src, err)
+		} else if val := (*node).(CharacterNode).Value; val != test.little {
+			t.Errorf("%s little endian: expected %#x, got %#x",
+				test.src, test.little, val)
+		}
+
+		if node, err := big.parseConstant(); err != nil {
+			t.Errorf("%s: %v", test.src, err)
+		} else if val := (*node).(CharacterNode).Value; val != test.big {
+			t.Errorf("%s big endian: expected %#x, got %#x",
+				test.src, test.big, val)
+		}
+	}
+
+	parser := NewParser("", strings.NewReader(`'ab' 'abc'`))
+	parser.Target.WordSize = 2
+
+	if _, err := parser.parseConstant(); err != nil {
+		t.Errorf("Two characters in 16 bits: %v", err)
+	}
+
+	if _, err := parser.parseConstant(); err == nil {
+		t.Errorf("Three characters in 16 bits")
+	}
+
+	// The limit is the target's, and is reported inside expressions
+	parser = NewParser("", strings.NewReader(`x = 'abc'`))
+	parser.Target.WordSize = 2
+
+	if _, err := parser.parseExpression(); err == nil ||
+		!strings.Contains(err.Error(), "do not fit in a 2 byte word") {
+		t.Errorf("Three characters in 16 bits: %v", err)
+	}
+
+	if _, err := ParseExpr("", `'abcdefgh'`); err != nil {
+		t.Errorf("Eight characters in 64 bits: %v", err)
+	}
+
+	if _, err := ParseExpr("", `f('abcdefghi')`); err == nil ||
+		!strings.Contains(err.Error(), "do not fit in a 8 byte word") {
+		t.Errorf("Nine characters in 64 bits: %v", err)
+	}
+}
+
+func TestParseReportsLexErrors(t *testing.T) {
+	// The first token failing to lex used to panic
+	parser := NewParser("", strings.NewReader(`$ a 1;
+b '*x';
+c "*y";`))
+
+	unit, err := parser.Parse()
+	if errs, ok := err.(ErrorList); !ok || len(errs) != 3 {
+		t.Fatalf("Expected 3 errors, got: %v", err)
+	}
+
+	// The bad constants are skipped, leaving empty declarations
+	if len(unit.Vars) != 3 {
+		t.Errorf("Expected partial unit, got: %v", unit)
+	}
+
+	// Lex errors after a parse error are still reported
+	parser = NewParser("", strings.NewReader(`a 1 2; b '*x'; c "*y";`))
+
+	_, err = parser.Parse()
+	if errs, ok := err.(ErrorList); !ok || len(errs) != 3 {
+		t.Errorf("Expected 3 errors, got: %v", err)
+	} else if _, ok := errs[0].(*ParseError); !ok {
+		t.Errorf("Expected parse error first: %v", errs)
+	}
+}
+
+var binaryOps = []string{
+	"*", "/", "%", "+", "-", "<<", ">>", "<", "<=", ">", ">=", "==", "!=",
+	"&", "^", "|", "=", "=+", "=-", "=*", "=/", "=%", "=<<", "=>>", "=&",
+	"=|", "=^", "===", "=!=", "=<", "=<=", "=>", "=>=",
+}
+
+// Every pair of operators should group according to the precedence and
+// binding in OperatorPrecedence.
+func TestParseOperatorPairs(t *testing.T) {
+	for _, op1 := range binaryOps {
+		for _, op2 := range binaryOps {
+			src := fmt.Sprintf("a %s b %s c", op1, op2)
+
+			prec1, _ := OperatorPrecedence(op1)
+			prec2, bind := OperatorPrecedence(op2)
+
+			var expect string
+			if prec1 > prec2 || (prec1 == prec2 && bind == opLR) {
+				expect = fmt.Sprintf("((a %s b) %s c)", op1, op2)
+			} else {
+				expect = fmt.Sprintf("(a %s (b %s c))", op1, op2)
+			}
+
+			node, err := NewParser("", strings.NewReader(src)).
+				parseExpression()
+			if err != nil {
+				t.Errorf("%s: %v", src, err)
+			} else if str := (*node).(BinaryNode).StringWithPrecedence(); str != expect {
+				t.Errorf("%s: expected %s, got %s", src, expect, str)
+			}
+		}
+	}
+}
+
+func TestParseTernaryPrecedence(t *testing.T) {
+	var tests = []struct{ src, expect string }{
+		{"x = c ? a : b", "x = (c ? a : b)"},
+		{"c ? a : b ? d : e", "(c ? a : (b ? d : e))"},
+		{"c ? x = a : b", "(c ? x = a : b)"},
+		{"a | b ? c + d : e & f", "(a | b ? c + d : e & f)"},
+		{"x =+ c < d ? 1 : -1", "x =+ (c < d ? 1 : -1)"},
+	}
+
+	for _, test := range tests {
+		node, err := NewParser("", strings.NewReader(test.src)).
+			parseExpression()
+		if err != nil {
+			t.Errorf("%s: %v", test.src, err)
+		} else if str := (*node).String(); str != test.expect {
+			t.Errorf("%s: expected %s, got %s", test.src, test.expect,
+				str)
+		}
+	}
+}
+
+func TestParsePostfixChains(t *testing.T) {
+	var tests = []struct{ src, expect string }{
+		{"m[i][j]", "m[i][j]"},
+		{"table[k](x)", "table[k](x)"},
+		{"get()(1)", "get()(1)"},
+		{"v[i](a, b)[2]++", "v[i](a, b)[2]++"},
+		{"*p++", "*p++"},
+		{"-f(x)[0]--", "-f(x)[0]--"},
+	}
+
+	for _, test := range tests {
+		node, err := NewParser("", strings.NewReader(test.src)).
+			parseExpression()
+		if err != nil {
+			t.Errorf("%s: %v", test.src, err)
+		} else if str := (*node).String(); str != test.expect {
+			t.Errorf("%s: expected %s, got %s", test.src, test.expect,
+				str)
+		}
+	}
+
+	// m[i][j] is (m[i])[j]
+	node, _ := NewParser("", strings.NewReader("m[i][j]")).parseExpression()
+	outer, ok := (*node).(ArrayAccessNode)
+	if !ok {
+		t.Fatalf("expected array access, got %T", *node)
+	}
+	if inner, ok := outer.Array.(ArrayAccessNode); !ok {
+		t.Errorf("expected nested array access, got %T", outer.Array)
+	} else if inner.Index.String() != "i" || outer.Index.String() != "j" {
+		t.Errorf("wrong nesting: %v", outer)
+	}
+
+	// table[k](x) is a call of table[k]
+	node, _ = NewParser("", strings.NewReader("table[k](x)")).parseExpression()
+	if call, ok := (*node).(FunctionCallNode); !ok {
+		t.Errorf("expected function call, got %T", *node)
+	} else if _, ok := call.Callable.(ArrayAccessNode); !ok {
+		t.Errorf("expected array access callee, got %T", call.Callable)
+	}
+
+	// *p++ increments the pointer, not the value
+	node, _ = NewParser("", strings.NewReader("*p++")).parseExpression()
+	if un, ok := (*node).(UnaryNode); !ok || un.Oper != "*" {
+		t.Errorf("expected indirection, got %v", *node)
+	} else if inner, ok := un.Node.(UnaryNode); !ok || !inner.Postfix {
+		t.Errorf("expected postfix increment, got %v", un.Node)
+	}
+}
+
+func TestParseRecovery(t *testing.T) {
+	parser := NewParser("", strings.NewReader(`
+a 1;
+b 1 2;
+f() {
+	x = ;
+	if (y +) {
+		y = 1;
+		z = 2;
+	}
+	return (1;
+	good = 3;
+}
+c ];
+g(a) {
+	return (a);
+}`))
+
+	unit, err := parser.Parse()
+
+	errs, ok := err.(ErrorList)
+	if !ok {
+		t.Fatalf("Expected error list, got: %v", err)
+	}
+
+	expectLines := []int{3, 5, 6, 10, 13}
+	if len(errs) != len(expectLines) {
+		t.Fatalf("Expected %d errors, got %d: %v", len(expectLines),
+			len(errs), errs)
+	}
+
+	for i, line := range expectLines {
+		if pos := errorPos(errs[i]); pos.Line != line {
+			t.Errorf("Expected error on line %d, got: %v", line, errs[i])
+		}
+	}
+
+	if len(unit.Funcs) != 2 || unit.Funcs[0].Name != "f" ||
+		unit.Funcs[1].Name != "g" {
+		t.Errorf("Expected both functions, got: %v", unit.Funcs)
+	}
+
+	if len(unit.Vars) != 1 || len(unit.Bad) != 2 {
+		t.Errorf("Expected 1 var and 2 bad declarations, got: %v, %v",
+			unit.Vars, unit.Bad)
+	}
+
+	// Bad statements are replaced, the rest of the body is kept
+	body := unit.Funcs[0].Body.(BlockNode).Nodes
+	expect := []string{"<bad>", "<bad>", "<bad>", "good = 3;"}
+	if len(body) != len(expect) {
+		t.Fatalf("Expected %d statements, got: %v", len(expect), body)
+	}
+
+	for i, str := range expect {
+		if body[i].String() != str {
+			t.Errorf("Expected `%s`, got `%v`", str, body[i])
+		}
+	}
+
+	if bad := body[1].(BadNode); bad.Pos().Line != 6 || bad.End().Line != 9 {
+		t.Errorf("Expected bad if statement to span lines 6-9: %v - %v",
+			bad.Pos(), bad.End())
+	}
+
+	// Unterminated block
+	parser = NewParser("", strings.NewReader("f() { x = ; "))
+	if _, err := parser.Parse(); err == nil {
+		t.Errorf("Expected errors")
+	} else if errs := err.(ErrorList); len(errs) != 2 {
+		t.Errorf("Expected 2 errors, got: %v", errs)
+	}
+}
+
+func TestParseFragments(t *testing.T) {
+	expr, err := ParseExpr("", "a[i] =+ f(x)")
+	if err != nil {
+		t.Errorf("ParseExpr: %v", err)
+	} else if _, ok := expr.(BinaryNode); !ok {
+		t.Errorf("ParseExpr: expected binary node, got %T", expr)
+	}
+
+	stmt, err := ParseStmt("", []byte("while (i < n) i++;"))
+	if err != nil {
+		t.Errorf("ParseStmt: %v", err)
+	} else if _, ok := stmt.(WhileNode); !ok {
+		t.Errorf("ParseStmt: expected while node, got %T", stmt)
+	}
+
+	top, err := ParseTopLevel("", strings.NewReader("main() { return (0); }"))
+	if err != nil {
+		t.Errorf("ParseTopLevel: %v", err)
+	} else if fn, ok := top.(FunctionNode); !ok || fn.Name != "main" {
+		t.Errorf("ParseTopLevel: expected main, got %v", top)
+	}
+
+	var bad = []struct {
+		parse func(string, interface{}) (Node, error)
+		src   string
+	}{
+		{ParseExpr, "a + b c"},
+		{ParseExpr, "a +"},
+		{ParseExpr, "a; b"},
+		{ParseStmt, "x = 1; y = 2;"},
+		{ParseStmt, "x = 1"},
+		{ParseTopLevel, "a 1; b 2;"},
+		{ParseTopLevel, "f() {} }"},
+	}
+
+	for _, test := range bad {
+		if _, err := test.parse("", test.src); err == nil {
+			t.Errorf("%q: expected error", test.src)
+		}
+	}
+
+	// Trailing input is reported where it starts
+	_, err = ParseExpr("expr", "a + b c")
+	if errs, ok := err.(ErrorList); !ok || len(errs) != 1 {
+		t.Errorf("Expected one error, got %v", err)
+	} else if pos := errorPos(errs[0]); pos.Column != 7 {
+		t.Errorf("Expected error at column 7, got %v", pos)
+	}
+
+	if _, err := ParseExpr("", 42); err == nil {
+		t.Errorf("Expected error for invalid source type")
+	}
+}
+
+func TestParseConstantExpressions(t *testing.T) {
+	unit, err := NewParser("", strings.NewReader(`
+v[2 * 8] 1, 2;
+f(x) {
+	auto a[4 + 4], b, c['a' - 'a' + 1];
+	switch (x) {
+	case -1:
+	case 'a' + 1:
+	case (1 << 3) | 1:
+		;
+	}
+}`)).Parse()
+
+	if err != nil {
+		t.Fatalf("Parse failed: %v", err)
+	}
+
+	if vec := unit.Vars[0].(ExternVecInitNode); vec.Size != 16 {
+		t.Errorf("Expected vector size 16, got %d", vec.Size)
+	} else if vec.String() != "v [2 * 8] 1, 2;" {
+		t.Errorf("Expected size as written, got %v", vec)
+	}
+
+	body := unit.Funcs[0].Body.(BlockNode).Nodes
+
+	decl := body[0].(VarDeclNode)
+	if decl.Vars[0].Size != 8 || decl.Vars[2].Size != 1 {
+		t.Errorf("Wrong vector sizes: %v", decl.Vars)
+	}
+
+	cases := SwitchCases(body[1].(SwitchNode))
+	for i, val := range []int64{-1, 98, 9} {
+		if cases[i].Value != val {
+			t.Errorf("Expected case %d, got %d", val, cases[i].Value)
+		}
+	}
+
+	var bad = []string{
+		"v[n] 1;",
+		"v[-1] 1;",
+		"f() { auto a[x]; }",
+		"f() { auto a[1 / 0]; }",
+		"f(x) { switch (x) { case x: ; } }",
+		"f(x) { switch (x) { case f(): ; } }",
+	}
+
+	for _, src := range bad {
+		_, err := NewParser("", strings.NewReader(src)).Parse()
+		if err == nil {
+			t.Errorf("%s: expected error", src)
+		} else if errs := err.(ErrorList); len(errs) != 1 {
+			t.Errorf("%s: expected one error, got %v", src, errs)
+		} else if !strings.Contains(errs[0].Error(), "not a constant") &&
+			!strings.Contains(errs[0].Error(), "negative vector size") &&
+			!strings.Contains(errs[0].Error(), "division by zero") {
+			t.Errorf("%s: unclear error %v", src, errs[0])
+		}
+	}
+}
+
+func TestParseExternalDefinitions(t *testing.T) {
+	unit, err := NewParser("", strings.NewReader(`
+a;
+b 1;
+c 1, 'x', "str", main;
+d[10];
+e[2] 1, 2, 3;
+f[] a, b, 3;
+g[];
+main() {}
+`)).Parse()
+
+	if err != nil {
+		t.Fatalf("Parse failed: %v", err)
+	}
+
+	var expect = []string{
+		"a;", "b 1;", "c 1, 'x', \"str\", main;", "d [10];",
+		"e [2] 1, 2, 3;", "f [] a, b, 3;", "g [];",
+	}
+
+	if len(unit.Vars) != len(expect) {
+		t.Fatalf("Expected %d definitions, got %v", len(expect), unit.Vars)
+	}
+
+	for i, str := range expect {
+		if unit.Vars[i].String() != str {
+			t.Errorf("Expected `%s`, got `%v`", str, unit.Vars[i])
+		}
+	}
+
+	if vals := unit.Vars[2].(ExternVarInitNode).Values; len(vals) != 4 {
+		t.Errorf("Expected 4 values, got %v", vals)
+	} else if _, ok := vals[3].(IdentNode); !ok {
+		t.Errorf("Expected name value, got %T", vals[3])
+	}
+
+	if vec := unit.Vars[3].(ExternVecInitNode); vec.Size != 10 ||
+		len(vec.Values) != 0 {
+		t.Errorf("Expected empty vector of size 10, got %v", vec)
+	}
+
+	if vec := unit.Vars[5].(ExternVecInitNode); !vec.InferSize ||
+		vec.Size != 2 {
+		t.Errorf("Expected inferred size 2, got %d", vec.Size)
+	}
+
+	if err = unit.Verify(); err != nil {
+		t.Errorf("Verify failed: %v", err)
+	}
+
+	var bad = []string{"a 1 2;", "a[] 1 2;", "a[;", "a, 1;", "a 1,;"}
+
+	for _, src := range bad {
+		if _, err := NewParser("", strings.NewReader(src)).Parse(); err == nil {
+			t.Errorf("%s: expected parse error", src)
+		}
+	}
+}
+
+func TestParseGoto(t *testing.T) {
+	var tests = []struct{ src, expect string }{
+		{"goto label;", "goto label;"},
+		{"goto states[i];", "goto states[i];"},
+		{"goto x ? a : b;", "goto (x ? a : b);"},
+		{"goto *p;", "goto *p;"},
+	}
+
+	for _, test := range tests {
+		node, err := ParseStmt("", test.src)
+		if err != nil {
+			t.Errorf("%s: %v", test.src, err)
+		} else if node.String() != test.expect {
+			t.Errorf("%s: expected %s, got %v", test.src, test.expect, node)
+		}
+	}
+
+	node, _ := ParseStmt("", "goto label;")
+	if name, ok := node.(GotoNode).Label(); !ok || name != "label" {
+		t.Errorf("Expected direct goto to label, got %v", node)
+	}
+
+	node, _ = ParseStmt("", "goto v[1];")
+	if _, ok := node.(GotoNode).Label(); ok {
+		t.Errorf("Expected computed goto, got %v", node)
+	}
+
+	if _, err := ParseStmt("", "goto;"); err == nil {
+		t.Errorf("Expected error for goto without a target")
+	}
+}
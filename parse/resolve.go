@@ -0,0 +1,303 @@
+package parse
+
+import (
+	"fmt"
+	"sort"
+	"text/scanner"
+)
+
+type DeclKind int
+
+const (
+	ParamDecl  DeclKind = iota // function parameter
+	AutoDecl                   // auto variable or vector
+	ExtrnDecl                  // a name brought in with extrn
+	LabelDecl                  // goto label
+	GlobalDecl                 // external defined in the unit
+	FuncDecl                   // function defined in the unit
+)
+
+func (k DeclKind) String() string {
+	switch k {
+	case ParamDecl:
+		return "parameter"
+	case AutoDecl:
+		return "auto"
+	case ExtrnDecl:
+		return "extrn"
+	case LabelDecl:
+		return "label"
+	case GlobalDecl:
+		return "global"
+	case FuncDecl:
+		return "function"
+	}
+
+	return "unknown"
+}
+
+// What a name is bound to.
+type Decl struct {
+	Kind DeclKind
+	Name string
+
+	// The declaring node: the function for a parameter or a function,
+	// the auto, extrn or label statement, or the global's definition.
+	// nil for an implicit extrn.
+	Node Node
+
+	// For an extrn, the global or function of the unit it names, if it
+	// isn't defined elsewhere.
+	Def Node
+
+	// An extrn implied by calling an undeclared name, as B allows.
+	Implicit bool
+}
+
+// Side table binding each identifier of a unit to its declaration.
+type Symbols struct {
+	bindings map[scanner.Position]*Decl
+}
+
+// The declaration ident refers to, or nil if it's undeclared.
+//
+// As nodes aren't comparable, identifiers are looked up by their source
+// position. One without a position, as from a tree put together or
+// rewritten rather than parsed, or sharing its position with another,
+// as when a node is copied, can't be told apart: Resolve reports these
+// as errors, and Lookup returns nil for them.
+func (s *Symbols) Lookup(ident IdentNode) *Decl {
+	pos := ident.Pos()
+	if !pos.IsValid() {
+		return nil
+	}
+
+	return s.bindings[pos]
+}
+
+func (s *Symbols) bind(ident IdentNode, decl *Decl) error {
+	pos := ident.Pos()
+
+	if !pos.IsValid() {
+		return NewSemanticError(ident, ident.Value+
+			" has no source position to be looked up by")
+	}
+
+	if old, ok := s.bindings[pos]; ok && old != decl {
+		delete(s.bindings, pos)
+		return NewSemanticError(ident, ident.Value+
+			" shares its source position with another identifier")
+	}
+
+	s.bindings[pos] = decl
+	return nil
+}
+
+// Bind every identifier in the unit to its declaration. Within a
+// function, names are those of its parameters, autos, extrns and
+// labels: globals are only visible once named in an extrn, except that
+// calling an undeclared name declares it extrn. The table is returned
+// even if some names are undeclared, which are reported together.
+//
+// Identifiers are bound by source position, so the unit has to have
+// been parsed: see Symbols.Lookup.
+func (t TranslationUnit) Resolve() (*Symbols, error) {
+	syms := &Symbols{bindings: map[scanner.Position]*Decl{}}
+
+	if errs := t.resolve(syms.bind); len(errs) > 0 {
+		return syms, errs
+	}
+
+	return syms, nil
+}
+
+// Check every name used is declared, calling bind, if it isn't nil, for
+// each identifier which is.
+func (t TranslationUnit) resolve(bind func(IdentNode, *Decl) error) ErrorList {
+	globals := t.globalDecls()
+
+	var errs ErrorList
+
+	found := func(ident IdentNode, decl *Decl) {
+		if bind == nil {
+			return
+		}
+
+		if err := bind(ident, decl); err != nil {
+			errs = append(errs, err)
+		}
+	}
+
+	// Names given as initial values are addresses of globals
+	for _, v := range t.Vars {
+		for _, val := range Children(v) {
+			ident, ok := val.(IdentNode)
+			if !ok {
+				continue
+			}
+
+			if decl := globals[ident.Value]; decl != nil {
+				found(ident, decl)
+			} else {
+				errs = append(errs, NewSemanticError(ident,
+					"undeclared name "+ident.Value))
+			}
+		}
+	}
+
+	for _, fn := range t.Funcs {
+		locals := t.localDecls(fn, globals)
+
+		Inspect(fn.Body, func(node Node) bool {
+			if n, ok := node.(IdentNode); ok {
+				if decl := locals[n.Value]; decl != nil {
+					found(n, decl)
+				} else if globals[n.Value] != nil {
+					errs = append(errs, NewSemanticError(n, fmt.Sprintf(
+						"%s used without an extrn declaration", n.Value)))
+				} else {
+					errs = append(errs, NewSemanticError(n,
+						"undeclared name "+n.Value))
+				}
+			}
+
+			return node != nil
+		})
+	}
+
+	sort.Sort(errs)
+	return errs
+}
+
+// The functions and externals of the unit. Where a name is defined
+// twice, the first definition is used.
+func (t TranslationUnit) globalDecls() map[string]*Decl {
+	globals := map[string]*Decl{}
+
+	for _, fn := range t.Funcs {
+		if globals[fn.Name] == nil {
+			globals[fn.Name] = &Decl{Kind: FuncDecl, Name: fn.Name, Node: fn}
+		}
+	}
+
+	for _, v := range t.Vars {
+		var name string
+
+		switch v.(type) {
+		case ExternVarInitNode:
+			name = v.(ExternVarInitNode).Name
+		case ExternVecInitNode:
+			name = v.(ExternVecInitNode).Name
+		}
+
+		if globals[name] == nil {
+			globals[name] = &Decl{Kind: GlobalDecl, Name: name, Node: v}
+		}
+	}
+
+	return globals
+}
+
+func globalDef(decl *Decl) Node {
+	if decl == nil {
+		return nil
+	}
+
+	return decl.Node
+}
+
+// The names declared in a function, which are all in scope throughout
+// it, including those declared extrn by being called. Where a name is
+// declared twice, the first declaration is used.
+func (t TranslationUnit) localDecls(fn FunctionNode,
+	globals map[string]*Decl) map[string]*Decl {
+
+	locals := map[string]*Decl{}
+
+	declare := func(decl *Decl) {
+		if locals[decl.Name] == nil {
+			locals[decl.Name] = decl
+		}
+	}
+
+	for _, param := range fn.Params {
+		declare(&Decl{Kind: ParamDecl, Name: param, Node: fn})
+	}
+
+	Inspect(fn.Body, func(node Node) bool {
+		switch n := node.(type) {
+		case ExternVarDeclNode:
+			for _, name := range n.Names {
+				declare(&Decl{Kind: ExtrnDecl, Name: name, Node: n,
+					Def: globalDef(globals[name])})
+			}
+
+		case LabelNode:
+			declare(&Decl{Kind: LabelDecl, Name: n.Name, Node: n})
+
+		case VarDeclNode:
+			for _, v := range n.Vars {
+				declare(&Decl{Kind: AutoDecl, Name: v.Name, Node: n})
+			}
+		}
+
+		return node != nil && !IsExpr(node)
+	})
+
+	// Calls only declare what isn't otherwise, wherever they are
+	Inspect(fn.Body, func(node Node) bool {
+		if call, ok := node.(FunctionCallNode); ok {
+			if ident, ok := call.Callable.(IdentNode); ok {
+				declare(&Decl{Kind: ExtrnDecl, Name: ident.Value,
+					Implicit: true, Def: globalDef(globals[ident.Value])})
+			}
+		}
+
+		return node != nil
+	})
+
+	return locals
+}
+
+// Check no name is declared twice in a function. Parameters, autos,
+// extrns and labels all share one scope.
+func (t TranslationUnit) resolveLocalDuplicates(fn FunctionNode) error {
+	seen := map[string]bool{}
+
+	for _, param := range fn.Params {
+		if seen[param] {
+			return NewSemanticError(fn, "Duplicate parameter name "+param)
+		}
+		seen[param] = true
+	}
+
+	var err error
+
+	check := func(node Node, name, msg string) {
+		if err == nil && seen[name] {
+			err = NewSemanticError(node, msg+" "+name)
+		}
+		seen[name] = true
+	}
+
+	Inspect(fn.Body, func(node Node) bool {
+		switch n := node.(type) {
+		case ExternVarDeclNode:
+			for _, name := range n.Names {
+				check(n, name, "Duplicate extrn name")
+			}
+
+		case LabelNode:
+			check(n, n.Name, "Duplicate label name")
+
+		case VarDeclNode:
+			for _, v := range n.Vars {
+				check(n, v.Name, "Duplicate auto variable name")
+			}
+		}
+
+		return err == nil && node != nil && !IsExpr(node)
+	})
+
+	return err
+}
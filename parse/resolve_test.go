@@ -0,0 +1,186 @@
+package parse
+
+import (
+	"strings"
+	"testing"
+)
+
+func TestResolve(t *testing.T) {
+	unit, err := NewParser("x.b", strings.NewReader(`
+v[] f, g;
+g 1;
+f(a) {
+	extrn g, outside;
+	auto b;
+l:	b = a + g + outside;
+	goto l;
+	printf("%d", b);
+	printf;
+	return (f(b));
+}`)).Parse()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	syms, err := unit.Resolve()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	var got []string
+
+	for _, def := range unit.Defs {
+		Inspect(def, func(node Node) bool {
+			if ident, ok := node.(IdentNode); ok {
+				decl := syms.Lookup(ident)
+				if decl == nil {
+					t.Errorf("%v: %s not bound", ident.Pos(), ident.Value)
+					return false
+				}
+
+				str := ident.Value + ":" + decl.Kind.String()
+				if decl.Implicit {
+					str += "(implicit)"
+				}
+				if decl.Def != nil {
+					str += "->" + decl.Def.Pos().String()
+				}
+
+				got = append(got, str)
+			}
+			return true
+		})
+	}
+
+	expected := "f:function g:global " +
+		"b:auto a:parameter g:extrn->x.b:3:1 outside:extrn l:label " +
+		"printf:extrn(implicit) b:auto printf:extrn(implicit) " +
+		"f:extrn(implicit)->x.b:4:1 b:auto"
+
+	if strings.Join(got, " ") != expected {
+		t.Errorf("Expected:\n%s\ngot:\n%s", expected, strings.Join(got, " "))
+	}
+
+	// Every use of a name shares its declaration
+	fn := unit.Funcs[0]
+	stmts := fn.Body.(BlockNode).Nodes
+	ret := stmts[len(stmts)-1].(ReturnNode).Node.(ParenNode).Node
+	call := ret.(FunctionCallNode)
+	auto := stmts[1].(VarDeclNode)
+
+	if decl := syms.Lookup(call.Args[0].(IdentNode)); decl.Node == nil ||
+		decl.Node.Pos() != auto.Pos() {
+		t.Errorf("Expected b bound to %v, got %v", auto, decl.Node)
+	}
+}
+
+func TestResolveErrors(t *testing.T) {
+	unit, err := NewParser("x.b", strings.NewReader(`
+g 1;
+v[] g, nothing;
+f() {
+	x = g;
+	h();
+	y = h;
+	z;
+}`)).Parse()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	syms, err := unit.Resolve()
+	if err == nil {
+		t.Fatal("Expected undeclared names to be reported")
+	}
+
+	errs, ok := err.(ErrorList)
+	if !ok {
+		t.Fatalf("Expected an ErrorList, got %v", err)
+	}
+
+	expected := []string{
+		"undeclared name nothing",
+		"undeclared name x",
+		"g used without an extrn declaration",
+		"undeclared name y",
+		"undeclared name z",
+	}
+
+	if len(errs) != len(expected) {
+		t.Fatalf("Expected %d errors, got:\n%v", len(expected), err)
+	}
+
+	for i, msg := range expected {
+		if !strings.HasSuffix(errs[i].Error(), msg) {
+			t.Errorf("Expected %q, got %q", msg, errs[i])
+		}
+	}
+
+	// Whatever could be bound still is
+	stmt := unit.Funcs[0].Body.(BlockNode).Nodes[2].(StatementNode)
+	h := stmt.Expr.(BinaryNode).Right.(IdentNode)
+
+	if decl := syms.Lookup(h); decl == nil || !decl.Implicit {
+		t.Errorf("Expected h to be an implicit extrn, got %v", decl)
+	}
+
+	if err := unit.Verify(); err == nil {
+		t.Errorf("Verify passed with undeclared names")
+	}
+}
+
+// A call declares its name extrn throughout the function, even where it's
+// used before the call.
+func TestResolveCallBeforeUse(t *testing.T) {
+	unit, err := NewParser("x.b", strings.NewReader(`
+f() {
+	auto x;
+	x = g;
+	g();
+}
+g() {}`)).Parse()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	syms, err := unit.Resolve()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	stmt := unit.Funcs[0].Body.(BlockNode).Nodes[1].(StatementNode)
+	g := stmt.Expr.(BinaryNode).Right.(IdentNode)
+
+	if decl := syms.Lookup(g); decl == nil || !decl.Implicit ||
+		decl.Def == nil {
+
+		t.Errorf("Expected g to be an implicit extrn of g(), got %v", decl)
+	}
+}
+
+// Identifiers without positions can't be looked up, so aren't bound, but
+// the names can still be checked.
+func TestResolveWithoutPositions(t *testing.T) {
+	x := IdentNode{Value: "x"}
+
+	unit := TranslationUnit{Funcs: []FunctionNode{{Name: "f",
+		Params: []string{"x"},
+		Body: BlockNode{Nodes: []Node{
+			StatementNode{Expr: BinaryNode{Left: x, Oper: "=+",
+				Right: IntegerNode{Value: 1, Radix: 10}}},
+		}},
+	}}}
+
+	syms, err := unit.Resolve()
+	if err == nil || !strings.Contains(err.Error(), "no source position") {
+		t.Errorf("Expected an error for x without a position, got %v", err)
+	}
+
+	if decl := syms.Lookup(x); decl != nil {
+		t.Errorf("Expected x not to be found, got %v", decl)
+	}
+
+	if err := unit.Verify(); err != nil {
+		t.Errorf("Verify failed: %v", err)
+	}
+}
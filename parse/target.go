@@ -0,0 +1,42 @@
+package parse
+
+import (
+	"encoding/binary"
+	"fmt"
+)
+
+// Target describes the machine that B words are laid out on. Literals
+// are checked against it while parsing.
+type Target struct {
+	WordSize  int              // bytes in a machine word
+	ByteOrder binary.ByteOrder // order characters are packed in a word
+}
+
+// The C backend represents a word as a 64 bit B_AUTO.
+var DefaultTarget = Target{WordSize: 8, ByteOrder: binary.LittleEndian}
+
+// Number of bits in a machine word
+func (t Target) WordBits() int { return t.WordSize * 8 }
+
+// Pack the characters of a character constant into a single word. The
+// characters are right justified in the word, so 'a' == 97 regardless
+// of byte order; with a big endian order the first character ends up
+// in the most significant position.
+func (t Target) PackChars(chars []byte) (int64, error) {
+	if len(chars) > t.WordSize {
+		return 0, fmt.Errorf("%d characters do not fit in a %d byte word",
+			len(chars), t.WordSize)
+	}
+
+	var word uint64
+
+	for i, char := range chars {
+		if t.ByteOrder == binary.BigEndian {
+			word = word<<8 | uint64(char)
+		} else {
+			word |= uint64(char) << (8 * uint(i))
+		}
+	}
+
+	return int64(word), nil
+}
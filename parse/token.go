@@ -14,78 +14,99 @@ const (
 type TokenType int
 
 const (
-	tkError TokenType = iota
-	tkEof
-	tkNumber
-	tkIdent
-	tkOpenBrace
-	tkCloseBrace
-	tkOpenParen
-	tkCloseParen
-	tkOpenBracket
-	tkCloseBracket
-	tkString
-	tkSemicolon
-	tkComma
-	tkColon
-	tkCharacter
-	tkKeyword
-	tkTernary
-	tkOperator // Composite type of most operators
+	TokError TokenType = iota
+	TokEof
+	TokNumber
+	TokIdent
+	TokOpenBrace
+	TokCloseBrace
+	TokOpenParen
+	TokCloseParen
+	TokOpenBracket
+	TokCloseBracket
+	TokString
+	TokSemicolon
+	TokComma
+	TokColon
+	TokCharacter
+	TokKeyword
+	TokTernary
+	TokOperator // Composite type of most operators
 )
 
 type Token struct {
-	kind       TokenType
-	value      string
-	start, end scanner.Position
+	Kind       TokenType
+	Value      string // contents, without quotes for strings and characters
+	Text       string // exactly as written in the source
+	Start, End scanner.Position
+	Trivia     []Trivia // preceding whitespace and comments
+}
+
+type TriviaKind int
+
+const (
+	Whitespace TriviaKind = iota
+	Comment
+)
+
+// Source text that doesn't affect the meaning of the program. Only
+// collected by a lexer created with NewTriviaLexer.
+type Trivia struct {
+	Kind       TriviaKind
+	Text       string
+	Start, End scanner.Position
 }
 
 func (t *Token) Error() Token {
 	return Token{
-		kind:  tkError,
-		value: t.String(),
-		start: t.start,
-		end:   t.end,
+		Kind:   TokError,
+		Value:  t.String(),
+		Text:   t.Text,
+		Start:  t.Start,
+		End:    t.End,
+		Trivia: t.Trivia,
 	}
 }
 
+func (t Token) span() Span { return Span{t.Start, t.End} }
+
 func (t TokenType) String() string {
 	switch t {
-	case tkError:
+	case TokError:
 		return "ERROR"
-	case tkEof:
+	case TokEof:
 		return "EOF"
-	case tkNumber:
+	case TokNumber:
 		return "Number"
-	case tkIdent:
+	case TokIdent:
 		return "Identifier"
-	case tkOpenBrace:
+	case TokOpenBrace:
 		return "Open Brace"
-	case tkCloseBrace:
+	case TokCloseBrace:
 		return "Close Brace"
-	case tkOpenParen:
+	case TokOpenParen:
 		return "Open Paren"
-	case tkCloseParen:
+	case TokCloseParen:
 		return "Close Paren"
-	case tkOpenBracket:
+	case TokOpenBracket:
 		return "Open bracket"
-	case tkCloseBracket:
+	case TokCloseBracket:
 		return "Close bracket"
-	case tkString:
+	case TokString:
 		return "String"
-	case tkSemicolon:
+	case TokSemicolon:
 		return "Semicolon"
-	case tkComma:
+	case TokComma:
 		return "Comma"
-	case tkColon:
+	case TokColon:
 		return "Colon"
-	case tkCharacter:
+	case TokCharacter:
 		return "Character"
-	case tkKeyword:
+	case TokKeyword:
 		return "Keyword"
-	case tkOperator:
+	case TokOperator:
 		return "Operator"
-	case tkTernary:
+	case TokTernary:
 		return "Ternary"
 	}
 
@@ -94,7 +115,7 @@ func (t TokenType) String() string {
 }
 
 func (t Token) String() string {
-	return t.kind.String() + ": " + t.value
+	return t.Kind.String() + ": " + t.Value
 }
 
 func OperatorPrecedence(op string) (prec int, bind OperatorBinding) {
@@ -103,6 +124,8 @@ func OperatorPrecedence(op string) (prec int, bind OperatorBinding) {
 		return 90, opLR
 	case "+", "-":
 		return 80, opLR
+	case "<<", ">>":
+		return 75, opLR
 	case ">", "<", "<=", ">=":
 		return 70, opLR
 	case "==", "!=":
@@ -115,9 +138,49 @@ func OperatorPrecedence(op string) (prec int, bind OperatorBinding) {
 		return 30, opLR
 	case "?":
 		return 20, opRL
-	case "=", "=+", "=-", "=/", "=*": // etc
+	case "=", "=+", "=-", "=*", "=/", "=%", "=<<", "=>>", "=&", "=|", "=^",
+		"===", "=!=", "=<", "=<=", "=>", "=>=":
 		return 10, opRL
 	}
 
 	return -1, -1
 }
+
+// Precedence of an expression as the operand of another, higher binding
+// more tightly: that of its operator for binary and ternary
+// expressions, above which come prefix operators, and then everything
+// else.
+func Precedence(node Node) int {
+	switch n := node.(type) {
+	case BinaryNode:
+		prec, _ := OperatorPrecedence(n.Oper)
+		return prec
+	case TernaryNode:
+		prec, _ := OperatorPrecedence("?")
+		return prec
+	case UnaryNode:
+		if !n.Postfix {
+			return UnaryPrecedence
+		}
+	}
+
+	return PrimaryPrecedence
+}
+
+const (
+	UnaryPrecedence   = 100
+	PrimaryPrecedence = 110
+)
+
+// Operators that can join two expressions, not counting the ternary
+func IsBinaryOp(op string) bool {
+	prec, _ := OperatorPrecedence(op)
+	return prec >= 0 && op != "?"
+}
+
+// '=' and the compound assignments such as '=+' or '==='
+func IsAssignOp(op string) bool {
+	prec, _ := OperatorPrecedence(op)
+	assign, _ := OperatorPrecedence("=")
+	return prec == assign
+}
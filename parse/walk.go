@@ -0,0 +1,214 @@
+package parse
+
+import "fmt"
+
+// Visit is called for each node found by Walk. If the visitor w it
+// returns is not nil, Walk visits each of the node's children with w,
+// and then calls w.Visit(nil).
+type Visitor interface {
+	Visit(node Node) (w Visitor)
+}
+
+// Traverse an AST depth first, in source order, as go/ast.Walk does.
+func Walk(v Visitor, node Node) {
+	if v = v.Visit(node); v == nil {
+		return
+	}
+
+	for _, child := range Children(node) {
+		Walk(v, child)
+	}
+
+	v.Visit(nil)
+}
+
+type inspector func(Node) bool
+
+func (f inspector) Visit(node Node) Visitor {
+	if f(node) {
+		return f
+	}
+	return nil
+}
+
+// Call f(node) for each node in the AST. If f returns true, the node's
+// children are inspected, followed by a call of f(nil).
+func Inspect(node Node, f func(Node) bool) {
+	Walk(inspector(f), node)
+}
+
+// The nodes directly below node, in source order. A missing optional
+// part, such as the size of a vector declared without one, is left out.
+func Children(node Node) []Node {
+	var children []Node
+
+	add := func(nodes ...Node) {
+		for _, n := range nodes {
+			if n != nil {
+				children = append(children, n)
+			}
+		}
+	}
+
+	switch n := node.(type) {
+	case ArrayAccessNode:
+		add(n.Array, n.Index)
+	case BinaryNode:
+		add(n.Left, n.Right)
+	case BlockNode:
+		add(n.Nodes...)
+	case CaseLabelNode:
+		add(n.Cond)
+	case ExternVarInitNode:
+		add(n.Values...)
+	case ExternVecInitNode:
+		add(n.SizeExpr)
+		add(n.Values...)
+	case FunctionNode:
+		add(n.Body)
+	case FunctionCallNode:
+		add(n.Callable)
+		add(n.Args...)
+	case GotoNode:
+		add(n.Target)
+	case IfNode:
+		add(n.Cond, n.Body)
+		if n.HasElse {
+			add(n.ElseBody)
+		}
+	case ParenNode:
+		add(n.Node)
+	case ReturnNode:
+		add(n.Node)
+	case StatementNode:
+		add(n.Expr)
+	case SwitchNode:
+		add(n.Cond, n.Body)
+	case TernaryNode:
+		add(n.Cond, n.TrueBody, n.FalseBody)
+	case UnaryNode:
+		add(n.Node)
+	case VarDeclNode:
+		for _, decl := range n.Vars {
+			add(decl.SizeExpr)
+		}
+	case WhileNode:
+		add(n.Cond, n.Body)
+
+	case BadNode, BreakNode, CharacterNode, ExternVarDeclNode, IdentNode,
+		IntegerNode, LabelNode, NullNode, StringNode:
+		// No children
+
+	default:
+		panic(fmt.Sprintf("Children: unexpected node type %T", node))
+	}
+
+	return children
+}
+
+// Rebuild an AST from the bottom up. pre is called on each node first;
+// if it returns false, the node is left as it is. Otherwise its children
+// are rewritten, and post is called on the result, returning the node
+// to replace it with (which may be the same node).
+//
+// Returning nil from post removes a node from a list, such as the
+// statements of a block or the arguments of a call. Anywhere else it
+// leaves an empty field, which is only valid where the field is
+// optional. Either hook may be nil.
+func Rewrite(node Node, pre func(Node) bool, post func(Node) Node) Node {
+	if pre != nil && !pre(node) {
+		return node
+	}
+
+	one := func(n Node) Node {
+		if n == nil {
+			return nil
+		}
+		return Rewrite(n, pre, post)
+	}
+
+	list := func(nodes []Node) []Node {
+		if nodes == nil {
+			return nil
+		}
+
+		out := make([]Node, 0, len(nodes))
+		for _, n := range nodes {
+			if n = one(n); n != nil {
+				out = append(out, n)
+			}
+		}
+		return out
+	}
+
+	switch n := node.(type) {
+	case ArrayAccessNode:
+		n.Array, n.Index = one(n.Array), one(n.Index)
+		node = n
+	case BinaryNode:
+		n.Left, n.Right = one(n.Left), one(n.Right)
+		node = n
+	case BlockNode:
+		n.Nodes = list(n.Nodes)
+		node = n
+	case CaseLabelNode:
+		n.Cond = one(n.Cond)
+		node = n
+	case ExternVarInitNode:
+		n.Values = list(n.Values)
+		node = n
+	case ExternVecInitNode:
+		n.SizeExpr, n.Values = one(n.SizeExpr), list(n.Values)
+		node = n
+	case FunctionNode:
+		n.Body = one(n.Body)
+		node = n
+	case FunctionCallNode:
+		n.Callable, n.Args = one(n.Callable), list(n.Args)
+		node = n
+	case GotoNode:
+		n.Target = one(n.Target)
+		node = n
+	case IfNode:
+		n.Cond, n.Body = one(n.Cond), one(n.Body)
+		if n.HasElse {
+			n.ElseBody = one(n.ElseBody)
+		}
+		node = n
+	case ParenNode:
+		n.Node = one(n.Node)
+		node = n
+	case ReturnNode:
+		n.Node = one(n.Node)
+		node = n
+	case StatementNode:
+		n.Expr = one(n.Expr)
+		node = n
+	case SwitchNode:
+		n.Cond, n.Body = one(n.Cond), one(n.Body)
+		node = n
+	case TernaryNode:
+		n.Cond = one(n.Cond)
+		n.TrueBody, n.FalseBody = one(n.TrueBody), one(n.FalseBody)
+		node = n
+	case UnaryNode:
+		n.Node = one(n.Node)
+		node = n
+	case VarDeclNode:
+		vars := make([]VarDecl, len(n.Vars))
+		for i, decl := range n.Vars {
+			decl.SizeExpr = one(decl.SizeExpr)
+			vars[i] = decl
+		}
+		n.Vars = vars
+		node = n
+	case WhileNode:
+		n.Cond, n.Body = one(n.Cond), one(n.Body)
+		node = n
+	}
+
+	if post != nil {
+		return post(node)
+	}
+	return node
+}
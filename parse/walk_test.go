@@ -0,0 +1,213 @@
+package parse
+
+import (
+	"fmt"
+	"strings"
+	"testing"
+)
+
+const walkSource = `
+v[2 * 2] 1, name;
+f(a, b) {
+	auto x, y[3];
+	extrn v;
+lab:
+	x = a[b](1, -b) + (b ? 2 : 3);
+	if (x) y[0] = 1; else goto lab;
+	while (x--) switch (x) { case 'a': break; default: ; }
+	return (x);
+}
+`
+
+func parseWalkSource(t *testing.T) TranslationUnit {
+	unit, err := NewParser("", strings.NewReader(walkSource)).Parse()
+	if err != nil {
+		t.Fatalf("Parse failed: %v", err)
+	}
+	return unit
+}
+
+type depthVisitor struct {
+	depth int
+	trace *[]string
+}
+
+func (v depthVisitor) Visit(node Node) Visitor {
+	if node == nil {
+		*v.trace = append(*v.trace, fmt.Sprintf("%d end", v.depth-1))
+		return nil
+	}
+
+	*v.trace = append(*v.trace, fmt.Sprintf("%d %T", v.depth, node))
+	return depthVisitor{v.depth + 1, v.trace}
+}
+
+func TestWalk(t *testing.T) {
+	expr, err := ParseExpr("", "f(a + 1, -b)")
+	if err != nil {
+		t.Fatalf("Parse failed: %v", err)
+	}
+
+	var trace []string
+	Walk(depthVisitor{0, &trace}, expr)
+
+	expect := []string{
+		"0 parse.FunctionCallNode",
+		"1 parse.IdentNode", "1 end",
+		"1 parse.BinaryNode",
+		"2 parse.IdentNode", "2 end",
+		"2 parse.IntegerNode", "2 end",
+		"1 end",
+		"1 parse.UnaryNode",
+		"2 parse.IdentNode", "2 end",
+		"1 end",
+		"0 end",
+	}
+
+	if strings.Join(trace, "\n") != strings.Join(expect, "\n") {
+		t.Errorf("Expected:\n%s\ngot:\n%s", strings.Join(expect, "\n"),
+			strings.Join(trace, "\n"))
+	}
+}
+
+func TestInspect(t *testing.T) {
+	unit := parseWalkSource(t)
+
+	var idents []string
+	for _, def := range unit.Defs {
+		Inspect(def, func(node Node) bool {
+			if ident, ok := node.(IdentNode); ok {
+				idents = append(idents, ident.Value)
+			}
+			return true
+		})
+	}
+
+	expect := "name x a b b b x y lab x x x"
+	if str := strings.Join(idents, " "); str != expect {
+		t.Errorf("Expected %s, got %s", expect, str)
+	}
+
+	// Returning false skips the children
+	count := 0
+	Inspect(unit.Funcs[0], func(node Node) bool {
+		if node != nil {
+			count++
+		}
+		_, ok := node.(FunctionNode)
+		return ok
+	})
+
+	if count != 2 {
+		t.Errorf("Expected function and body only, got %d nodes", count)
+	}
+}
+
+// Every node has its children, in source order
+func TestChildren(t *testing.T) {
+	unit := parseWalkSource(t)
+
+	for _, def := range unit.Defs {
+		Inspect(def, func(node Node) bool {
+			if node == nil {
+				return false
+			}
+
+			prev := node.Pos()
+			for _, child := range Children(node) {
+				if child.Pos().Offset < prev.Offset ||
+					child.End().Offset > node.End().Offset {
+					t.Errorf("%v: child %v of %T out of order", child.Pos(),
+						child, node)
+				}
+				prev = child.End()
+			}
+			return true
+		})
+	}
+
+	var sizes []string
+	Inspect(unit.Defs[1], func(node Node) bool {
+		if decl, ok := node.(VarDeclNode); ok {
+			for _, child := range Children(decl) {
+				sizes = append(sizes, child.String())
+			}
+		}
+		return true
+	})
+
+	if len(sizes) != 1 || sizes[0] != "3" {
+		t.Errorf("Expected vector size as child, got %v", sizes)
+	}
+}
+
+func TestRewrite(t *testing.T) {
+	unit := parseWalkSource(t)
+	fn := unit.Funcs[0]
+	before := fn.String()
+
+	// Fold constants and drop redundant parens
+	fold := func(node Node) Node {
+		switch node.(type) {
+		case BinaryNode, UnaryNode:
+			if val, err := DefaultTarget.Eval(node); err == nil {
+				return IntegerNode{Span: Span{node.Pos(), node.End()},
+					Value: val, Radix: 10}
+			}
+		case ParenNode:
+			if _, ok := node.(ParenNode).Node.(IntegerNode); ok {
+				return node.(ParenNode).Node
+			}
+		}
+		return node
+	}
+
+	vec := Rewrite(unit.Vars[0], nil, fold)
+	if str := vec.String(); str != "v [4] 1, name;" {
+		t.Errorf("Expected folded size, got %s", str)
+	}
+
+	// Remove statements, and leave the switch alone entirely
+	visited := 0
+	out := Rewrite(fn, func(node Node) bool {
+		_, ok := node.(SwitchNode)
+		return !ok
+	}, func(node Node) Node {
+		visited++
+
+		switch node.(type) {
+		case ExternVarDeclNode, LabelNode:
+			return nil
+		case IdentNode:
+			if node.(IdentNode).Value == "x" {
+				return IdentNode{Span: node.(IdentNode).Span, Value: "z"}
+			}
+		}
+		return node
+	})
+
+	expect := `f(a, b) {
+	auto x, y[3];
+	z = a[b](1, -b) + ((b ? 2 : 3));
+	if(z) y[0] = 1; else goto lab;
+	while(z--) switch(x) {
+	case 'a':
+	break;
+	default:
+	
+}
+	return (z);
+}`
+
+	if str := out.String(); str != expect {
+		t.Errorf("Expected:\n%s\ngot:\n%s", expect, str)
+	}
+
+	if fn.String() != before {
+		t.Errorf("Rewrite changed its input")
+	}
+
+	if visited == 0 {
+		t.Errorf("post never called")
+	}
+}
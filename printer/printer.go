@@ -0,0 +1,462 @@
+// Package printer writes ASTs back out as B source, laid out the one
+// canonical way: tab indentation, one statement per line, spaces around
+// binary operators and after keywords and commas.
+package printer
+
+import (
+	"bytes"
+	"fmt"
+	"github.com/erik/gob/parse"
+	"io"
+	"strconv"
+	"strings"
+	"text/scanner"
+)
+
+type printer struct {
+	out      bytes.Buffer
+	indent   int
+	pending  int  // newlines owed before the next output
+	line     int  // source line of the last thing printed, 0 if unknown
+	open     bool // nothing printed since an opening brace
+	blank    bool // the next line must be preceded by a blank one
+	comments []parse.Trivia
+	err      error
+}
+
+// Print node as B source. A statement or definition is printed without
+// a trailing newline, as it would be inside a larger tree.
+func Fprint(w io.Writer, node parse.Node) error {
+	var p printer
+
+	switch {
+	case parse.IsExpr(node):
+		p.write(p.expr(node))
+	default:
+		p.statement(node)
+	}
+
+	return p.flushTo(w)
+}
+
+// Print a whole unit, placing comments, which must be in source order,
+// among the definitions and statements as they were in the source. A
+// comment inside an expression ends up before the statement holding it.
+func FprintUnit(w io.Writer, unit parse.TranslationUnit,
+	comments []parse.Trivia) error {
+
+	p := printer{comments: comments}
+
+	wasFunc := false
+
+	for _, def := range unit.Definitions() {
+		_, isFunc := def.(parse.FunctionNode)
+
+		// Functions are always set apart from what's around them
+		p.blank = isFunc || wasFunc
+		wasFunc = isFunc
+
+		p.stmt(def)
+	}
+
+	p.flush(-1)
+
+	if p.out.Len() > 0 {
+		p.out.WriteString("\n")
+	}
+
+	return p.flushTo(w)
+}
+
+// The comments among the trivia of tokens, in order.
+func Comments(toks []parse.Token) []parse.Trivia {
+	var comments []parse.Trivia
+
+	for _, tok := range toks {
+		for _, trivia := range tok.Trivia {
+			if trivia.Kind == parse.Comment {
+				comments = append(comments, trivia)
+			}
+		}
+	}
+
+	return comments
+}
+
+// Format B source canonically, keeping its comments. The result is
+// checked to parse back to the same AST, so the meaning of a file is
+// never changed by formatting it.
+func Source(name string, src []byte) ([]byte, error) {
+	// The parser reports any lexical errors as well
+	toks, _ := parse.Tokenize(name, bytes.NewReader(src))
+
+	unit, err := parse.NewParser(name, bytes.NewReader(src)).Parse()
+	if err != nil {
+		return nil, err
+	}
+
+	var buf bytes.Buffer
+	if err := FprintUnit(&buf, unit, Comments(toks)); err != nil {
+		return nil, err
+	}
+
+	out, err := parse.NewParser(name, bytes.NewReader(buf.Bytes())).Parse()
+	if err != nil || !equalUnits(unit, out) {
+		return nil, fmt.Errorf("%s: formatted source doesn't match the "+
+			"original", name)
+	}
+
+	return buf.Bytes(), nil
+}
+
+func equalUnits(a, b parse.TranslationUnit) bool {
+	if len(a.Defs) != len(b.Defs) {
+		return false
+	}
+
+	for i := range a.Defs {
+		if !parse.Equal(a.Defs[i], b.Defs[i]) {
+			return false
+		}
+	}
+
+	return true
+}
+
+func (p *printer) flushTo(w io.Writer) error {
+	if p.err != nil {
+		return p.err
+	}
+
+	_, err := w.Write(p.out.Bytes())
+	return err
+}
+
+func (p *printer) write(s string) {
+	if p.pending > 0 {
+		p.out.WriteString(strings.Repeat("\n", p.pending))
+		p.out.WriteString(strings.Repeat("\t", p.indent))
+		p.pending = 0
+	}
+
+	p.out.WriteString(s)
+	p.open = false
+}
+
+// Write s one level to the left, as for labels.
+func (p *printer) outdent(s string) {
+	if p.indent == 0 {
+		p.write(s)
+		return
+	}
+
+	p.indent--
+	p.write(s)
+	p.indent++
+}
+
+func (p *printer) newline() {
+	if p.out.Len() > 0 && p.pending == 0 {
+		p.pending = 1
+	}
+}
+
+// Start a new line for something from pos, keeping a single blank line
+// where the source had any.
+func (p *printer) startLine(pos scanner.Position) {
+	p.newline()
+
+	gap := pos.IsValid() && p.line > 0 && pos.Line > p.line+1
+
+	if p.out.Len() > 0 && !p.open && (p.blank || gap) {
+		p.pending = 2
+	}
+
+	p.blank = false
+}
+
+func (p *printer) setLine(pos scanner.Position) {
+	if pos.IsValid() {
+		p.line = pos.Line
+	}
+}
+
+// Print the comments starting before offset, or all of them if it's
+// negative. One following something on the same line stays there.
+func (p *printer) flush(offset int) {
+	for len(p.comments) > 0 &&
+		(offset < 0 || p.comments[0].Start.Offset < offset) {
+
+		comment := p.comments[0]
+		p.comments = p.comments[1:]
+
+		if p.line > 0 && comment.Start.Line == p.line {
+			p.write(" " + comment.Text)
+		} else {
+			p.startLine(comment.Start)
+			p.write(comment.Text)
+		}
+
+		p.setLine(comment.End)
+	}
+}
+
+// Print a statement or definition on a line of its own.
+func (p *printer) stmt(node parse.Node) {
+	if pos := node.Pos(); pos.IsValid() {
+		p.flush(pos.Offset)
+	}
+
+	p.startLine(node.Pos())
+	p.statement(node)
+	p.setLine(node.End())
+}
+
+// The body of a function or control statement: a block goes on the
+// same line, anything else on the next, indented. Reports whether it
+// was a block.
+func (p *printer) body(node parse.Node) bool {
+	if block, ok := node.(parse.BlockNode); ok {
+		p.write(" ")
+		p.block(block)
+		return true
+	}
+
+	p.indent++
+	p.open = true
+	p.stmt(node)
+	p.indent--
+
+	return false
+}
+
+func (p *printer) block(block parse.BlockNode) {
+	p.write("{")
+	p.open = true
+	p.setLine(block.Pos())
+
+	p.indent++
+
+	for _, node := range block.Nodes {
+		p.stmt(node)
+	}
+
+	if end := block.End(); end.IsValid() {
+		p.flush(end.Offset)
+	}
+
+	p.indent--
+
+	if !p.open {
+		p.newline()
+	}
+
+	p.write("}")
+	p.setLine(block.End())
+}
+
+func (p *printer) statement(node parse.Node) {
+	switch n := node.(type) {
+	case parse.BlockNode:
+		p.block(n)
+
+	case parse.BreakNode:
+		p.write("break;")
+
+	case parse.CaseLabelNode:
+		if n.Default {
+			p.outdent("default:")
+		} else {
+			p.outdent("case " + p.expr(n.Cond) + ":")
+		}
+
+	case parse.ExternVarDeclNode:
+		p.write("extrn " + strings.Join(n.Names, ", ") + ";")
+
+	case parse.ExternVarInitNode:
+		p.write(n.Name + p.values(n.Values) + ";")
+
+	case parse.ExternVecInitNode:
+		size := ""
+		if !n.InferSize {
+			size = p.size(n.SizeExpr, n.Size)
+		}
+
+		p.write(n.Name + "[" + size + "]" + p.values(n.Values) + ";")
+
+	case parse.FunctionNode:
+		p.write(n.Name + "(" + strings.Join(n.Params, ", ") + ")")
+		p.body(n.Body)
+
+	case parse.GotoNode:
+		p.write("goto " + p.expr(n.Target) + ";")
+
+	case parse.IfNode:
+		p.write("if (" + p.expr(n.Cond) + ")")
+		block := p.body(n.Body)
+
+		if !n.HasElse {
+			break
+		}
+
+		if block {
+			p.write(" else")
+		} else {
+			p.newline()
+			p.write("else")
+		}
+
+		// Keep else if chains flat
+		if elif, ok := n.ElseBody.(parse.IfNode); ok {
+			p.write(" ")
+			p.statement(elif)
+		} else {
+			p.body(n.ElseBody)
+		}
+
+	case parse.LabelNode:
+		p.outdent(n.Name + ":")
+
+	case parse.NullNode:
+		p.write(";")
+
+	case parse.ReturnNode:
+		if _, ok := n.Node.(parse.NullNode); ok || n.Node == nil {
+			p.write("return;")
+		} else {
+			p.write("return " + p.expr(n.Node) + ";")
+		}
+
+	case parse.StatementNode:
+		p.write(p.expr(n.Expr) + ";")
+
+	case parse.SwitchNode:
+		p.write("switch (" + p.expr(n.Cond) + ")")
+		p.body(n.Body)
+
+	case parse.VarDeclNode:
+		decls := make([]string, len(n.Vars))
+
+		for i, decl := range n.Vars {
+			decls[i] = decl.Name
+			if decl.VecDecl {
+				decls[i] += "[" + p.size(decl.SizeExpr, decl.Size) + "]"
+			}
+		}
+
+		p.write("auto " + strings.Join(decls, ", ") + ";")
+
+	case parse.WhileNode:
+		p.write("while (" + p.expr(n.Cond) + ")")
+		p.body(n.Body)
+
+	default:
+		p.fail(node)
+	}
+}
+
+// Initial values of an external, with the space leading up to them.
+func (p *printer) values(values []parse.Node) string {
+	if len(values) == 0 {
+		return ""
+	}
+
+	return " " + p.exprList(values)
+}
+
+// A vector size, as written where possible.
+func (p *printer) size(expr parse.Node, size int) string {
+	if expr != nil {
+		return p.expr(expr)
+	}
+
+	return strconv.Itoa(size)
+}
+
+func (p *printer) exprList(nodes []parse.Node) string {
+	strs := make([]string, len(nodes))
+
+	for i, node := range nodes {
+		strs[i] = p.expr(node)
+	}
+
+	return strings.Join(strs, ", ")
+}
+
+// Parentheses from the source are kept as they are. A tree put together
+// or rewritten may need more, for its operands to bind as they do in
+// the tree, so they're added wherever an operand binds less tightly
+// than its place calls for.
+func (p *printer) expr(node parse.Node) string {
+	switch n := node.(type) {
+	case parse.ArrayAccessNode:
+		return p.operand(n.Array, parse.PrimaryPrecedence) + "[" +
+			p.expr(n.Index) + "]"
+
+	case parse.BinaryNode:
+		prec, _ := parse.OperatorPrecedence(n.Oper)
+
+		// Assignments group right to left, everything else left to right
+		left, right := prec, prec+1
+		if parse.IsAssignOp(n.Oper) {
+			left, right = prec+1, prec
+		}
+
+		return p.operand(n.Left, left) + " " + n.Oper + " " +
+			p.operand(n.Right, right)
+
+	case parse.CharacterNode, parse.IdentNode, parse.IntegerNode,
+		parse.StringNode:
+		return n.String()
+
+	case parse.FunctionCallNode:
+		return p.operand(n.Callable, parse.PrimaryPrecedence) + "(" +
+			p.exprList(n.Args) + ")"
+
+	case parse.ParenNode:
+		return "(" + p.expr(n.Node) + ")"
+
+	case parse.TernaryNode:
+		prec, _ := parse.OperatorPrecedence("?")
+
+		return p.operand(n.Cond, prec+1) + " ? " + p.expr(n.TrueBody) +
+			" : " + p.operand(n.FalseBody, prec)
+
+	case parse.UnaryNode:
+		if n.Postfix {
+			return p.operand(n.Node, parse.PrimaryPrecedence) + n.Oper
+		}
+
+		operand := p.operand(n.Node, parse.UnaryPrecedence)
+
+		// - -x, not --x
+		if joins(n.Oper, operand) {
+			return n.Oper + " " + operand
+		}
+		return n.Oper + operand
+	}
+
+	p.fail(node)
+	return ""
+}
+
+// node as the operand of an operator of precedence prec
+func (p *printer) operand(node parse.Node, prec int) string {
+	if parse.Precedence(node) < prec {
+		return "(" + p.expr(node) + ")"
+	}
+
+	return p.expr(node)
+}
+
+func (p *printer) fail(node parse.Node) {
+	if p.err == nil {
+		p.err = fmt.Errorf("%v: can't print %T", node.Pos(), node)
+	}
+}
+
+// Whether op written directly before s would lex as something else.
+func joins(op, s string) bool {
+	toks, err := parse.Tokenize("", strings.NewReader(op+s))
+	return err != nil || len(toks) == 0 || toks[0].Text != op
+}
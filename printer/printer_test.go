@@ -0,0 +1,220 @@
+package printer
+
+import (
+	"github.com/erik/gob/parse"
+	"io/ioutil"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func TestSourceExamples(t *testing.T) {
+	files, err := filepath.Glob("../examples/*.b")
+	if err != nil || len(files) == 0 {
+		t.Fatalf("no examples found: %v", err)
+	}
+
+	for _, name := range files {
+		src, err := ioutil.ReadFile(name)
+		if err != nil {
+			t.Fatalf("failed to open test: %s", err)
+		}
+
+		// Source itself checks the output parses to the same AST
+		out, err := Source(name, src)
+		if err != nil {
+			t.Errorf("%s: %v", name, err)
+			continue
+		}
+
+		if again, err := Source(name, out); err != nil {
+			t.Errorf("%s: reformatting: %v", name, err)
+		} else if string(again) != string(out) {
+			t.Errorf("%s: formatting isn't stable:\n%s\n%s", name, out,
+				again)
+		}
+
+		// Every comment is kept
+		if strings.Count(string(out), "/*") != strings.Count(string(src),
+			"/*") {
+			t.Errorf("%s: comments lost:\n%s", name, out)
+		}
+	}
+}
+
+func TestSource(t *testing.T) {
+	src := `/* globals */
+v[]1,2 ; s "str" ;
+main( a,b ){auto x,y[ 2*2 ];  /* decls */
+  if(a)return ; else if (b) x=- -a; else { y[0] =!x ; }
+
+
+  switch(a){case 1: case 2:x++;break;default:goto l;}
+l:while(x--);
+  { }
+  /* the end */
+}
+f() return(0);`
+
+	expected := `/* globals */
+v[] 1, 2;
+s "str";
+
+main(a, b) {
+	auto x, y[2 * 2]; /* decls */
+	if (a)
+		return;
+	else if (b)
+		x =- -a;
+	else {
+		y[0] = !x;
+	}
+
+	switch (a) {
+	case 1:
+	case 2:
+		x++;
+		break;
+	default:
+		goto l;
+	}
+l:
+	while (x--)
+		;
+	{}
+	/* the end */
+}
+
+f()
+	return (0);
+`
+
+	out, err := Source("x.b", []byte(src))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if string(out) != expected {
+		t.Errorf("Expected:\n%s\ngot:\n%s", expected, out)
+	}
+
+	if _, err := Source("x.b", []byte("main() { x = ; }")); err == nil {
+		t.Errorf("Expected a syntax error to be reported")
+	}
+}
+
+func TestFprint(t *testing.T) {
+	ident := parse.IdentNode{Value: "x"}
+
+	var tests = []struct {
+		node     parse.Node
+		expected string
+	}{
+		{parse.ReturnNode{Node: parse.NullNode{}}, "return;"},
+		{parse.SwitchNode{Cond: ident, Body: parse.BlockNode{
+			Nodes: []parse.Node{parse.BreakNode{}}}},
+			"switch (x) {\n\tbreak;\n}"},
+		{parse.UnaryNode{Oper: "-", Node: parse.UnaryNode{Oper: "--",
+			Node: ident}}, "- --x"},
+		{parse.UnaryNode{Oper: "!", Node: parse.UnaryNode{Oper: "-",
+			Node: ident}}, "!-x"},
+		{parse.BinaryNode{Left: ident, Oper: "=", Right: parse.UnaryNode{
+			Oper: "-", Node: ident}}, "x = -x"},
+		{parse.WhileNode{Cond: ident, Body: parse.StatementNode{
+			Expr: ident}}, "while (x)\n\tx;"},
+
+		// Operands are parenthesized as their precedence needs
+		{parse.BinaryNode{Left: ident, Oper: "-", Right: parse.BinaryNode{
+			Left: ident, Oper: "-", Right: ident}}, "x - (x - x)"},
+		{parse.BinaryNode{Left: parse.BinaryNode{Left: ident, Oper: "=",
+			Right: ident}, Oper: "=", Right: ident}, "(x = x) = x"},
+		{parse.TernaryNode{Cond: parse.TernaryNode{Cond: ident,
+			TrueBody: ident, FalseBody: ident}, TrueBody: ident,
+			FalseBody: parse.BinaryNode{Left: ident, Oper: "=",
+				Right: ident}}, "(x ? x : x) ? x : (x = x)"},
+		{parse.UnaryNode{Oper: "++", Postfix: true, Node: parse.UnaryNode{
+			Oper: "*", Node: ident}}, "(*x)++"},
+		{parse.FunctionCallNode{Callable: parse.UnaryNode{Oper: "*",
+			Node: ident}}, "(*x)()"},
+	}
+
+	for _, test := range tests {
+		var buf strings.Builder
+
+		if err := Fprint(&buf, test.node); err != nil {
+			t.Errorf("%v: %v", test.node, err)
+		} else if buf.String() != test.expected {
+			t.Errorf("Expected %q, got %q", test.expected, buf.String())
+		}
+	}
+
+	if err := Fprint(ioutil.Discard, parse.BadNode{}); err == nil {
+		t.Errorf("Expected an error printing a BadNode")
+	}
+}
+
+func TestFprintUnitWithoutDefs(t *testing.T) {
+	unit := parse.TranslationUnit{
+		Funcs: []parse.FunctionNode{{Name: "f", Body: parse.BlockNode{}}},
+		Vars:  []parse.Node{parse.ExternVarInitNode{Name: "v"}},
+	}
+
+	var buf strings.Builder
+
+	if err := FprintUnit(&buf, unit, nil); err != nil {
+		t.Fatalf("FprintUnit failed: %v", err)
+	}
+
+	if expected := "v;\n\nf() {}\n"; buf.String() != expected {
+		t.Errorf("Expected %q, got %q", expected, buf.String())
+	}
+}
+
+// Printing a rewritten tree and parsing it again gives back the same
+// tree, apart from the parentheses needed to write it.
+func TestFprintRewritten(t *testing.T) {
+	expr, err := parse.ParseExpr("x.b", "a * 2 - -b")
+	if err != nil {
+		t.Fatalf("Parse failed: %v", err)
+	}
+
+	sum, _ := parse.ParseExpr("x.b", "x + y")
+	neg, _ := parse.ParseExpr("x.b", "c - d")
+
+	expr = parse.Rewrite(expr, nil, func(node parse.Node) parse.Node {
+		switch node.String() {
+		case "a":
+			return sum
+		case "b":
+			return neg
+		}
+		return node
+	})
+
+	var buf strings.Builder
+	if err := Fprint(&buf, expr); err != nil {
+		t.Fatalf("Fprint failed: %v", err)
+	}
+
+	if expected := "(x + y) * 2 - -(c - d)"; buf.String() != expected {
+		t.Errorf("Expected %q, got %q", expected, buf.String())
+	}
+
+	reparsed, err := parse.ParseExpr("x.b", buf.String())
+	if err != nil {
+		t.Fatalf("%s: %v", buf.String(), err)
+	}
+
+	if !parse.Equal(stripParens(reparsed), stripParens(expr)) {
+		t.Errorf("Round trip differs:\n%v\n%v", reparsed, expr)
+	}
+}
+
+func stripParens(node parse.Node) parse.Node {
+	return parse.Rewrite(node, nil, func(node parse.Node) parse.Node {
+		if paren, ok := node.(parse.ParenNode); ok {
+			return paren.Node
+		}
+		return node
+	})
+}
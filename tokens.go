@@ -0,0 +1,93 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"github.com/erik/gob/parse"
+	"os"
+	"strconv"
+	"strings"
+)
+
+type jsonPos struct {
+	Line   int `json:"line"`
+	Column int `json:"column"`
+}
+
+type jsonToken struct {
+	Kind  string  `json:"kind"`
+	Text  string  `json:"text"`
+	Start jsonPos `json:"start"`
+	End   jsonPos `json:"end"`
+}
+
+// `gob tokens [--json] file...`: print what the lexer makes of each file,
+// one token per line.
+func dumpTokens(files []string) {
+	if len(files) < 1 {
+		fmt.Println("Need to specify an input file")
+		return
+	}
+
+	for _, name := range files {
+		file, err := os.Open(name)
+		if err != nil {
+			fmt.Println(err)
+			os.Exit(1)
+		}
+
+		toks, err := parse.Tokenize(name, file)
+		file.Close()
+
+		if *jsonOut {
+			printTokensJSON(name, toks)
+		} else {
+			if len(files) > 1 {
+				fmt.Printf("==== %s ====\n", name)
+			}
+
+			printTokens(toks)
+		}
+
+		if err != nil {
+			fmt.Fprintln(os.Stderr, err)
+		}
+	}
+}
+
+func printTokens(toks []parse.Token) {
+	for _, tok := range toks {
+		text := tok.Text
+
+		// Keep to one line per token
+		if strings.ContainsAny(text, "\t\n\r") {
+			text = strconv.Quote(text)
+		}
+
+		fmt.Printf("%d:%d-%d:%d\t%v\t%s\n", tok.Start.Line,
+			tok.Start.Column, tok.End.Line, tok.End.Column, tok.Kind,
+			text)
+	}
+}
+
+// One JSON object per file, on a line of its own
+func printTokensJSON(name string, toks []parse.Token) {
+	out := struct {
+		File   string      `json:"file"`
+		Tokens []jsonToken `json:"tokens"`
+	}{File: name, Tokens: make([]jsonToken, len(toks))}
+
+	for i, tok := range toks {
+		out.Tokens[i] = jsonToken{
+			Kind:  tok.Kind.String(),
+			Text:  tok.Text,
+			Start: jsonPos{tok.Start.Line, tok.Start.Column},
+			End:   jsonPos{tok.End.Line, tok.End.Column},
+		}
+	}
+
+	if err := json.NewEncoder(os.Stdout).Encode(out); err != nil {
+		fmt.Println(err)
+		os.Exit(1)
+	}
+}